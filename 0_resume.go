@@ -0,0 +1,97 @@
+package wxweb
+
+import (
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strconv"
+
+  "github.com/buger/jsonparser"
+  "github.com/kwf2030/commons/pipeline"
+  "github.com/kwf2030/commons/time2"
+)
+
+const pushLoginUrlPath = "/webwxpushloginurl"
+
+type resumeReq struct {
+  *Bot
+}
+
+// 如果有保存的凭据，恢复后直接从init开始（qr、scan和redirect会跳过），
+// init失败则调用resumeFallback
+func (r *resumeReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  if r.store != nil {
+    sd, e := r.store.Load()
+    if e != nil {
+      r.logger.Printf("wxweb: load session failed: %v", e)
+    }
+    if e == nil && r.restoreSession(sd) {
+      r.session.Resumed = true
+      r.updatePaths()
+    }
+  }
+  ctx.Fire(val)
+}
+
+// 恢复的凭据无法init时，先尝试push登录（在手机上确认即可，不需要扫码），
+// push登录失败再从qr开始扫码登录
+func (bot *Bot) resumeFallback(ctx *pipeline.HandlerContext, val interface{}) {
+  bot.session.Resumed = false
+  if e := bot.ctx.Err(); e != nil {
+    bot.signInFailed(StageInit, e)
+    return
+  }
+  p := ctx.Pipeline()
+  bot.logger.Printf("wxweb: resume failed, try push login")
+  uuid, e := bot.pushLogin()
+  if e == nil && uuid != "" {
+    if next := p.Get(StageScan); next != nil {
+      bot.mu.Lock()
+      bot.session.UUID = uuid
+      bot.session.Pushed = true
+      bot.mu.Unlock()
+      next.Handler().Handle(next, val)
+      return
+    }
+  }
+  bot.mu.Lock()
+  bot.session.reset()
+  bot.mu.Unlock()
+  if next := p.Get(StageQR); next != nil {
+    next.Handler().Handle(next, val)
+  }
+}
+
+func (bot *Bot) pushLogin() (string, error) {
+  addr, _ := url.Parse(bot.session.BaseUrl + pushLoginUrlPath)
+  q := addr.Query()
+  q.Set("uin", strconv.FormatInt(bot.session.Uin, 10))
+  addr.RawQuery = q.Encode()
+  req, _ := http.NewRequestWithContext(bot.ctx, "GET", addr.String(), nil)
+  req.Header.Set("Referer", bot.session.Referer)
+  req.Header.Set("User-Agent", bot.userAgent)
+  resp, e := bot.httpDo(req)
+  if e != nil {
+    return "", e
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return "", ErrReq
+  }
+  return bot.parsePushLoginResp(resp)
+}
+
+func (bot *Bot) parsePushLoginResp(resp *http.Response) (string, error) {
+  // {"ret":"0","msg":"all ok","uuid":"xxx"}
+  body, e := ioutil.ReadAll(resp.Body)
+  if e != nil {
+    return "", e
+  }
+  bot.dump("0_"+bot.now().Format(time2.DateTimeFormatMs5), body)
+  ret, _ := jsonparser.GetString(body, "ret")
+  uuid, _ := jsonparser.GetString(body, "uuid")
+  if ret != "0" || uuid == "" {
+    return "", ErrResp
+  }
+  return uuid, nil
+}
@@ -0,0 +1,114 @@
+package wxweb
+
+import (
+  "io/ioutil"
+  "net/http"
+  "path"
+  "strings"
+  "sync"
+  "testing"
+
+  "github.com/kwf2030/commons/pipeline"
+)
+
+// 记录进入qr时的session（不是恢复的凭据时）
+type qrProbe struct {
+  *qrReq
+  got []session
+}
+
+func (p *qrProbe) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  if s := p.sessionCopy(); !s.Resumed {
+    p.got = append(p.got, s)
+  }
+  p.qrReq.Handle(ctx, val)
+}
+
+// 扫码确认后停止（代替redirect）
+type stopStage struct {
+  *Bot
+  got *session
+}
+
+func (s *stopStage) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  if s.session.Resumed {
+    ctx.Fire(val)
+    return
+  }
+  c := s.sessionCopy()
+  s.got = &c
+}
+
+// 保存的凭据init失败 -> push登录 -> 手机上没有确认 -> 扫码登录
+func TestResumeFallbackToQR(t *testing.T) {
+  var mu sync.Mutex
+  var scanned []string
+  rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+    body := ""
+    switch {
+    case strings.HasSuffix(req.URL.Path, "/webwxpushloginurl"):
+      body = `{"ret":"0","msg":"all ok","uuid":"uuid-push"}`
+    case strings.HasSuffix(req.URL.Path, "/jslogin"):
+      body = `window.QRLogin.code = 200; window.QRLogin.uuid = "uuid-qr";`
+    case strings.HasSuffix(req.URL.Path, "/login"):
+      uuid := req.URL.Query().Get("uuid")
+      mu.Lock()
+      scanned = append(scanned, uuid)
+      mu.Unlock()
+      body = `window.code=400;`
+      if uuid == "uuid-qr" {
+        body = `window.code=200;window.redirect_uri="https://wx2.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage?ticket=t";`
+      }
+    default:
+      return nil, errTestTransport
+    }
+    return &http.Response{
+      StatusCode: http.StatusOK,
+      Body:       ioutil.NopCloser(strings.NewReader(body)),
+      Header:     http.Header{},
+      Request:    req,
+    }, nil
+  })
+  store := NewFileSessionStore(path.Join(tempDir(t), "session.json"))
+  e := store.Save(&SessionData{
+    Host:     "wx.qq.com",
+    BaseUrl:  "https://wx.qq.com/cgi-bin/mmwebwx-bin",
+    Sid:      "sid",
+    SKey:     "skey",
+    Uin:      1,
+    SyncKey:  "1_1",
+    UserName: "@self",
+  })
+  if e != nil {
+    t.Fatal(e)
+  }
+  bot := newTestBot(t, rt, WithSessionStore(store))
+  bot.handler = &testHandler{}
+  qr := &qrProbe{qrReq: &qrReq{bot}}
+  stop := &stopStage{Bot: bot}
+  pipeline.New().AddLast(StageResume, &resumeReq{bot}).
+    AddLast(StageQR, qr).
+    AddLast(StageScan, &scanReq{bot}).
+    AddLast(StageRedirect, stop).
+    AddLast(StageInit, &initReq{bot}).
+    Fire(nil)
+
+  if e := bot.Err(); e != nil {
+    t.Fatalf("Err() = %v", e)
+  }
+  if want := []string{"uuid-push", "uuid-qr"}; strings.Join(scanned, ",") != strings.Join(want, ",") {
+    t.Errorf("scanned uuids = %v, want %v", scanned, want)
+  }
+  if len(qr.got) != 1 {
+    t.Fatalf("qr entered %d times, want 1", len(qr.got))
+  }
+  if s := qr.got[0]; s.Resumed || s.Pushed || s.UUID != "" || s.Sid != "" {
+    t.Errorf("session at qr: Resumed = %v, Pushed = %v, UUID = %q, Sid = %q, want cleared", s.Resumed, s.Pushed, s.UUID, s.Sid)
+  }
+  if stop.got == nil {
+    t.Fatal("scan not confirmed")
+  }
+  if s := stop.got; s.Resumed || s.Pushed || s.UUID != "uuid-qr" || !strings.HasPrefix(s.RedirectUrl, "https://wx2.qq.com/") {
+    t.Errorf("session after scan: Resumed = %v, Pushed = %v, UUID = %q, RedirectUrl = %q", s.Resumed, s.Pushed, s.UUID, s.RedirectUrl)
+  }
+}
@@ -6,6 +6,7 @@ import (
   "net/http"
   "net/url"
   "regexp"
+  "time"
 
   "github.com/kwf2030/commons/pipeline"
   "github.com/kwf2030/commons/time2"
@@ -23,34 +24,44 @@ type qrReq struct {
 }
 
 func (r *qrReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  if r.session.Resumed {
+    ctx.Fire(val)
+    return
+  }
   uuid, e := r.do()
   if e != nil {
-    r.handler.OnSignIn(e)
+    r.signInFailed(StageQR, e)
     return
   }
   if uuid == "" {
-    r.handler.OnSignIn(ErrResp)
+    r.signInFailed(StageQR, ErrResp)
     return
   }
+  // GetBotByUUID可能在其他goroutine中读取
+  r.mu.Lock()
+  if r.session.QRTime.IsZero() {
+    r.session.QRTime = time.Now()
+  }
   r.session.UUID = uuid
   r.session.QRCodeUrl = fmt.Sprintf("%s/%s", qrUrl, uuid)
-  r.handler.OnQRCode(r.session.QRCodeUrl)
+  r.mu.Unlock()
+  r.onQRCode(r.session.QRCodeUrl)
   ctx.Fire(val)
 }
 
 func (r *qrReq) do() (string, error) {
   addr, _ := url.Parse(uuidUrl)
   q := addr.Query()
-  q.Set("appid", "wx782c26e4c19acffb")
+  q.Set("appid", r.appId)
   q.Set("fun", "new")
-  q.Set("lang", "zh_CN")
+  q.Set("lang", r.lang)
   q.Set("_", timestampString13())
   q.Set("redirect_uri", "https://wx.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage")
   addr.RawQuery = q.Encode()
-  req, _ := http.NewRequest("GET", addr.String(), nil)
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", addr.String(), nil)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return "", e
   }
@@ -58,16 +69,16 @@ func (r *qrReq) do() (string, error) {
   if resp.StatusCode != http.StatusOK {
     return "", ErrReq
   }
-  return parseQRResp(resp)
+  return r.parseQRResp(resp)
 }
 
-func parseQRResp(resp *http.Response) (string, error) {
+func (bot *Bot) parseQRResp(resp *http.Response) (string, error) {
   // window.QRLogin.code = 200; window.QRLogin.uuid = "wbVC3cUBrQ==";
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
     return "", e
   }
-  dump("1_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  bot.dump("1_"+bot.now().Format(time2.DateTimeFormatMs5), body)
   data := string(body)
   match := uuidRegex.FindStringSubmatch(data)
   if len(match) != 2 {
@@ -1,6 +1,7 @@
 package wxweb
 
 import (
+  "encoding/base64"
   "io/ioutil"
   "net/http"
   "net/url"
@@ -18,68 +19,158 @@ const scanUrl = "https://login.weixin.qq.com/cgi-bin/mmwebwx-bin/login"
 var (
   scanStCodeRegex        = regexp.MustCompile(`code\s*=\s*(\d+)\s*;`)
   scanStRedirectURLRegex = regexp.MustCompile(`redirect_uri\s*=\s*"(.*)"`)
+  scanStAvatarRegex      = regexp.MustCompile(`userAvatar\s*=\s*'data:[^;]*;base64,([^']*)'`)
 )
 
+// 二维码过期后的刷新策略
+type QRPolicy struct {
+  // 每个二维码等待扫码和确认的时间，默认2分钟
+  ScanTimeout time.Duration
+
+  // 二维码过期后最多刷新几次（重新获取uuid并回调OnQRCode），
+  // 默认为0，即不刷新（二维码过期后登录失败，返回base.ErrTimeout），需要自动刷新时用WithQRPolicy设置
+  MaxRefresh int
+
+  // 从第一次获取二维码开始算的总超时时间，0表示不限制
+  Deadline time.Duration
+}
+
+var defaultQRPolicy = QRPolicy{
+  ScanTimeout: time.Minute * 2,
+  Deadline:    time.Minute * 10,
+}
+
+// 二维码过期通知（可选），
+// Handler实现了该接口时，每次二维码过期都会回调，
+// 如果还可以刷新，之后会再回调OnQRCode
+type QRExpiredHandler interface {
+  OnQRExpired()
+}
+
+// 扫码进度通知（可选），
+// Handler实现了该接口时，会在扫码、确认和二维码过期时回调
+type ScanHandler interface {
+  QRExpiredHandler
+
+  // 已扫码（等待在手机上确认），
+  // 参数为扫码人的头像（JPG），解析失败时为nil
+  OnScanned([]byte)
+
+  // 已在手机上确认登录
+  OnConfirmed()
+}
+
+func (bot *Bot) SetQRPolicy(policy QRPolicy) {
+  if policy.ScanTimeout <= 0 {
+    policy.ScanTimeout = defaultQRPolicy.ScanTimeout
+  }
+  bot.qrPolicy = policy
+}
+
 type scanReq struct {
   *Bot
 }
 
 func (r *scanReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
-  ch := make(chan string)
-  go r.check(ch)
-  redirectUrl := <-ch
-  close(ch)
+  if r.session.Resumed {
+    ctx.Fire(val)
+    return
+  }
+  redirectUrl := r.check()
+  if e := r.ctx.Err(); e != nil {
+    r.signInFailed(StageScan, e)
+    return
+  }
   if redirectUrl == "" {
-    // 如果是空，基本就是超时（一直没有扫描默认设置了2分钟超时），
+    // push登录没有确认，重新扫码登录
+    if r.session.Pushed {
+      r.mu.Lock()
+      r.session.reset()
+      r.mu.Unlock()
+      if next := ctx.Pipeline().Get(StageQR); next != nil {
+        next.Handler().Handle(next, val)
+        return
+      }
+    }
+    if h, ok := r.handler.(QRExpiredHandler); ok {
+      h.OnQRExpired()
+    }
+    if r.canRefresh() {
+      r.session.QRRefresh++
+      if next := ctx.Pipeline().Get(StageQR); next != nil {
+        next.Handler().Handle(next, val)
+        return
+      }
+    }
+    // 如果是空，基本就是超时（一直没有扫描或二维码已过期），
     // 微信基本不可能返回200状态码的同时返回空redirect_url
-    r.handler.OnSignIn(base.ErrTimeout)
+    r.signInFailed(StageScan, base.ErrTimeout)
     return
   }
   r.session.RedirectUrl = redirectUrl
+  r.session.Pushed = false
   ctx.Fire(val)
 }
 
-func (r *scanReq) check(ch chan<- string) {
-  loop := true
-  t := time.AfterFunc(time.Minute*2, func() {
-    if loop {
-      loop = false
-      r.session.State = StateScanTimeout
-      ch <- ""
+func (r *scanReq) canRefresh() bool {
+  if r.session.QRRefresh >= r.qrPolicy.MaxRefresh {
+    return false
+  }
+  if r.qrPolicy.Deadline > 0 && time.Since(r.session.QRTime) >= r.qrPolicy.Deadline {
+    return false
+  }
+  return true
+}
+
+// 当前二维码的等待时间，不超过总超时时间的剩余部分
+func (r *scanReq) timeout() time.Duration {
+  ret := r.qrPolicy.ScanTimeout
+  if r.qrPolicy.Deadline > 0 && !r.session.QRTime.IsZero() {
+    remain := r.qrPolicy.Deadline - time.Since(r.session.QRTime)
+    if remain < ret {
+      ret = remain
     }
-  })
-out:
-  for loop {
-    // 200（已确认），201（已扫描），408（未扫描）
-    code, addr, e := r.do()
+  }
+  return ret
+}
+
+func (r *scanReq) check() string {
+  deadline := time.Now().Add(r.timeout())
+  h, _ := r.handler.(ScanHandler)
+  for time.Now().Before(deadline) && r.ctx.Err() == nil {
+    // 200（已确认），201（已扫描），408（未扫描，长轮询超时后继续），400（二维码已过期）
+    resp, e := r.do()
     if e != nil {
-      sleep()
+      r.sleep()
       continue
     }
-    switch code {
+    switch resp.code {
     case 200:
-      t.Stop()
-      loop = false
-      r.session.State = StateConfirm
-      ch <- addr
-      break out
+      r.setState(StateConfirm)
+      if h != nil {
+        h.OnConfirmed()
+      }
+      return resp.redirectUrl
 
     case 201:
-      r.session.State = StateScan
-      sleep()
-      continue
+      // 只在第一次扫码时回调，之后等待确认期间会一直返回201
+      if r.setState(StateScan) {
+        if h != nil {
+          h.OnScanned(resp.avatar)
+        }
+      }
 
-    case 408:
-      t.Stop()
-      loop = false
-      r.session.State = StateScanTimeout
-      ch <- ""
-      break out
+    case 400:
+      r.setState(StateScanTimeout)
+      return ""
     }
+    r.sleep()
   }
+  r.setState(StateScanTimeout)
+  return ""
 }
 
-func (r *scanReq) do() (int, string, error) {
+func (r *scanReq) do() (scanResp, error) {
   addr, _ := url.Parse(scanUrl)
   q := addr.Query()
   q.Set("loginicon", "true")
@@ -88,47 +179,64 @@ func (r *scanReq) do() (int, string, error) {
   q.Set("uuid", r.session.UUID)
   q.Set("_", timestampString13())
   addr.RawQuery = q.Encode()
-  req, _ := http.NewRequest("GET", addr.String(), nil)
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", addr.String(), nil)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
-    return 0, "", e
+    return scanResp{}, e
   }
   defer resp.Body.Close()
   if resp.StatusCode != http.StatusOK {
-    return 0, "", ErrReq
+    return scanResp{}, ErrReq
   }
   // RedirectURL的Host可能是wx.qq.com、wx2.qq.com或其他地址，
   // 这个地址可能是根据帐号注册时间分配的，
   // 从下一步reqToken开始所有的请求必须使用相同的Host，否则会返回1100错误码，
   // wx2版本有些请求的query参数被省略了，暂时不用管
-  return parseScanResp(resp)
+  return r.parseScanResp(resp)
 }
 
-func parseScanResp(resp *http.Response) (int, string, error) {
+func (bot *Bot) parseScanResp(resp *http.Response) (scanResp, error) {
   // 如果是200，返回：window.code=200;window.redirect_uri=xxx
   // 如果是201，返回：window.code=201;window.userAvatar = 'data:img/jpg;base64,xxx'
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
-    return 0, "", e
+    return scanResp{}, e
   }
-  dump("2_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  bot.dump("2_"+bot.now().Format(time2.DateTimeFormatMs5), body)
   data := string(body)
   arr := scanStCodeRegex.FindStringSubmatch(data)
   if len(arr) != 2 {
-    return 0, "", ErrResp
+    return scanResp{}, ErrResp
   }
   code, e := strconv.Atoi(arr[1])
   if e != nil {
-    return 0, "", ErrResp
-  }
-  if code != 200 {
-    return code, "", nil
+    return scanResp{}, ErrResp
   }
-  arr = scanStRedirectURLRegex.FindStringSubmatch(data)
-  if len(arr) < 2 {
-    return code, "", ErrResp
+  ret := scanResp{code: code}
+  switch code {
+  case 200:
+    arr = scanStRedirectURLRegex.FindStringSubmatch(data)
+    if len(arr) < 2 {
+      return ret, ErrResp
+    }
+    ret.redirectUrl = arr[1]
+
+  case 201:
+    // 头像解析失败不影响登录
+    arr = scanStAvatarRegex.FindStringSubmatch(data)
+    if len(arr) == 2 {
+      ret.avatar, _ = base64.StdEncoding.DecodeString(arr[1])
+    }
   }
-  return code, arr[1], nil
+  return ret, nil
+}
+
+type scanResp struct {
+  code        int
+  redirectUrl string
+
+  // 扫码人的头像（仅201时有值）
+  avatar []byte
 }
@@ -0,0 +1,34 @@
+package wxweb
+
+import (
+  "testing"
+  "time"
+)
+
+func TestScanCanRefresh(t *testing.T) {
+  tests := []struct {
+    name    string
+    policy  *QRPolicy
+    refresh int
+    elapsed time.Duration
+    want    bool
+  }{
+    {"default", nil, 0, 0, false},
+    {"opt in", &QRPolicy{MaxRefresh: 2}, 0, 0, true},
+    {"max reached", &QRPolicy{MaxRefresh: 2}, 2, 0, false},
+    {"deadline", &QRPolicy{MaxRefresh: 2, Deadline: time.Minute}, 1, time.Minute * 2, false},
+    {"before deadline", &QRPolicy{MaxRefresh: 2, Deadline: time.Minute}, 1, time.Second, true},
+  }
+  for _, tt := range tests {
+    var opts []Option
+    if tt.policy != nil {
+      opts = append(opts, WithQRPolicy(*tt.policy))
+    }
+    bot := newTestBot(t, failingTransport(), opts...)
+    bot.session.QRRefresh = tt.refresh
+    bot.session.QRTime = time.Now().Add(-tt.elapsed)
+    if got := (&scanReq{bot}).canRefresh(); got != tt.want {
+      t.Errorf("%s: canRefresh() = %v, want %v", tt.name, got, tt.want)
+    }
+  }
+}
@@ -5,7 +5,6 @@ import (
   "io/ioutil"
   "net/http"
   "net/url"
-  "strings"
 
   "github.com/kwf2030/commons/pipeline"
   "github.com/kwf2030/commons/time2"
@@ -16,15 +15,32 @@ type redirectReq struct {
 }
 
 func (r *redirectReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
-  redirect, e := r.do()
-  if e != nil {
-    r.handler.OnSignIn(e)
+  if r.session.Resumed {
+    ctx.Fire(val)
     return
   }
-  if redirect == nil || redirect.PassTicket == "" || redirect.SKey == "" || redirect.WXSid == "" || redirect.WXUin == 0 {
-    r.handler.OnSignIn(ErrResp)
+  if e := r.run(); e != nil {
+    r.signInFailed(StageRedirect, e)
     return
   }
+  ctx.Fire(val)
+}
+
+// 用RedirectUrl获取凭据，重连时也会用到
+func (r *redirectReq) run() error {
+  redirect, e := r.do()
+  if e != nil {
+    return e
+  }
+  if redirect.Ret != 0 {
+    return retError(redirect.Ret, redirect.Message)
+  }
+  if redirect.PassTicket == "" || redirect.SKey == "" || redirect.WXSid == "" || redirect.WXUin == 0 {
+    return ErrResp
+  }
+  u, _ := url.Parse(r.session.RedirectUrl)
+  // 重连时可能有其他goroutine（如回调中发消息）在读取session
+  r.mu.Lock()
   r.session.PassTicket = redirect.PassTicket
   r.session.Sid = redirect.WXSid
   r.session.SKey = redirect.SKey
@@ -35,9 +51,10 @@ func (r *redirectReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
     SKey:     redirect.SKey,
     Uin:      redirect.WXUin,
   }
-  r.selectBaseUrl()
+  r.session.selectCluster(u.Hostname())
+  r.mu.Unlock()
   r.updatePaths()
-  ctx.Fire(val)
+  return nil
 }
 
 func (r *redirectReq) do() (*redirectResp, error) {
@@ -47,10 +64,10 @@ func (r *redirectReq) do() (*redirectResp, error) {
   q.Set("fun", "new")
   q.Set("version", "v2")
   u.RawQuery = q.Encode()
-  req, _ := http.NewRequest("GET", u.String(), nil)
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", u.String(), nil)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -58,28 +75,15 @@ func (r *redirectReq) do() (*redirectResp, error) {
   if resp.StatusCode != http.StatusOK {
     return nil, ErrReq
   }
-  return parseRedirectResp(resp)
-}
-
-func (r *redirectReq) selectBaseUrl() {
-  u, _ := url.Parse(r.session.RedirectUrl)
-  host := u.Hostname()
-  r.session.Host = host
-  switch {
-  case strings.Contains(host, "wx2"):
-    r.session.Host = "wx2.qq.com"
-    r.session.SyncCheckHost = "webpush.wx2.qq.com"
-    r.session.Referer = "https://wx2.qq.com/"
-    r.session.BaseUrl = "https://wx2.qq.com/cgi-bin/mmwebwx-bin"
-  }
+  return r.parseRedirectResp(resp)
 }
 
-func parseRedirectResp(resp *http.Response) (*redirectResp, error) {
+func (bot *Bot) parseRedirectResp(resp *http.Response) (*redirectResp, error) {
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
     return nil, e
   }
-  dump("3_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  bot.dump("3_"+bot.now().Format(time2.DateTimeFormatMs5), body)
   ret := &redirectResp{}
   e = xml.Unmarshal(body, ret)
   if e != nil {
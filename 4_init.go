@@ -21,43 +21,58 @@ type initReq struct {
 }
 
 func (r *initReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  if e := r.run(); e != nil {
+    if r.session.Resumed {
+      r.resumeFallback(ctx, val)
+      return
+    }
+    r.signInFailed(StageInit, e)
+    return
+  }
+  ctx.Fire(val)
+}
+
+// 用当前的凭据初始化，重连时也会用到
+func (r *initReq) run() error {
   c, e := r.do()
   if e != nil {
-    r.handler.OnSignIn(e)
-    return
+    return e
   }
   if c == nil || c.UserName == "" {
-    r.handler.OnSignIn(ErrResp)
-    return
+    return ErrResp
   }
   sk, ok := c.attr.Load("SyncKey")
   if !ok {
-    r.handler.OnSignIn(ErrResp)
-    return
+    return ErrResp
   }
+  // 重连时可能有其他goroutine（如回调中发消息）在读取session
+  r.mu.Lock()
   r.session.SyncKey = sk.(syncKey)
+  r.session.SyncCheckKey = sk.(syncKey)
   r.session.UserName = c.UserName
   if addr, ok := c.attr.Load("HeadImgUrl"); ok {
     r.session.AvatarUrl = fmt.Sprintf("https://%s%s", r.session.Host, addr.(string))
   }
-  r.self = c
-  ctx.Fire(val)
+  r.mu.Unlock()
+  r.setSelf(c)
+  return nil
 }
 
 func (r *initReq) do() (*Contact, error) {
   addr, _ := url.Parse(r.session.BaseUrl + initUrlPath)
   q := addr.Query()
+  q.Set("lang", r.lang)
   q.Set("pass_ticket", r.session.PassTicket)
   q.Set("r", timestampString10())
   addr.RawQuery = q.Encode()
   m := make(map[string]interface{}, 1)
   m["BaseRequest"] = r.session.BaseReq
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
   req.Header.Set("Content-Type", contentType)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -65,15 +80,18 @@ func (r *initReq) do() (*Contact, error) {
   if resp.StatusCode != http.StatusOK {
     return nil, ErrReq
   }
-  return parseInitResp(resp)
+  return r.parseInitResp(resp)
 }
 
-func parseInitResp(resp *http.Response) (*Contact, error) {
+func (bot *Bot) parseInitResp(resp *http.Response) (*Contact, error) {
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
     return nil, e
   }
-  dump("4_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  bot.dump("4_"+bot.now().Format(time2.DateTimeFormatMs5), body)
+  if e = checkBaseResp(body); e != nil {
+    return nil, e
+  }
   c := &Contact{raw: body, attr: &sync.Map{}}
   jsonparser.EachKey(body, func(i int, v []byte, _ jsonparser.ValueType, e error) {
     if e != nil {
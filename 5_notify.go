@@ -20,7 +20,7 @@ type notifyReq struct {
 func (r *notifyReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
   e := r.do()
   if e != nil {
-    r.handler.OnSignIn(e)
+    r.signInFailed(StageNotify, e)
     return
   }
   ctx.Fire(val)
@@ -38,11 +38,11 @@ func (r *notifyReq) do() error {
   m["FromUserName"] = r.session.UserName
   m["ToUserName"] = r.session.UserName
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
   req.Header.Set("Content-Type", contentType)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return e
   }
@@ -54,6 +54,6 @@ func (r *notifyReq) do() error {
   if e != nil {
     return e
   }
-  dump("5_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
-  return nil
+  r.dump("5_"+r.now().Format(time2.DateTimeFormatMs5), body)
+  return checkBaseResp(body)
 }
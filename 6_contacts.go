@@ -19,31 +19,26 @@ type contactsReq struct {
 func (r *contactsReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
   arr, e := r.do()
   if e != nil {
-    r.handler.OnSignIn(e)
+    r.signInFailed(StageContacts, e)
     return
   }
-  r.contacts = initContacts(arr, r.Bot)
-  r.StartTime = time2.Shanghai()
-  r.session.State = StateRunning
-  botsMutex.Lock()
-  bots[r.session.Uin] = r.Bot
-  botsMutex.Unlock()
-  r.handler.OnSignIn(nil)
+  r.setContacts(initContacts(arr, r.Bot))
   ctx.Fire(val)
 }
 
 func (r *contactsReq) do() ([]*Contact, error) {
   addr, _ := url.Parse(r.session.BaseUrl + contactsUrlPath)
   q := addr.Query()
+  q.Set("lang", r.lang)
   q.Set("pass_ticket", r.session.PassTicket)
   q.Set("r", timestampString13())
   q.Set("seq", "0")
   q.Set("skey", r.session.SKey)
   addr.RawQuery = q.Encode()
-  req, _ := http.NewRequest("GET", addr.String(), nil)
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", addr.String(), nil)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -51,15 +46,18 @@ func (r *contactsReq) do() ([]*Contact, error) {
   if resp.StatusCode != http.StatusOK {
     return nil, ErrReq
   }
-  return parseContactsResp(resp)
+  return r.parseContactsResp(resp)
 }
 
-func parseContactsResp(resp *http.Response) ([]*Contact, error) {
+func (bot *Bot) parseContactsResp(resp *http.Response) ([]*Contact, error) {
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
     return nil, e
   }
-  dump("6_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  bot.dump("6_"+bot.now().Format(time2.DateTimeFormatMs5), body)
+  if e = checkBaseResp(body); e != nil {
+    return nil, e
+  }
   cnt, _ := jsonparser.GetInt(body, "MemberCount")
   if cnt == 0 {
     cnt = 5000
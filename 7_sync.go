@@ -2,6 +2,7 @@ package wxweb
 
 import (
   "bytes"
+  "context"
   "encoding/json"
   "fmt"
   "io/ioutil"
@@ -20,77 +21,192 @@ const (
   syncUrlPath      = "/webwxsync"
 )
 
+// synccheck连续失败多少次后切换到备用Host
+const syncCheckHostFailures = 3
+
+// ContinueFlag不为0时最多连续webwxsync的次数
+const syncContinueMax = 10
+
+// synccheck返回的selector（除了0都需要webwxsync）
+const (
+  // 有新消息
+  SelectorMessage = 2
+
+  // 联系人变化（新增或删除联系人、保存群到通讯录、修改群名称、群成员数目变化等）
+  SelectorContact = 4
+
+  // 操作了手机（如进入或关闭聊天页面）
+  SelectorPhone = 7
+)
+
 var syncCheckRegex = regexp.MustCompile(`retcode\s*:\s*"(\d+)"\s*,\s*selector\s*:\s*"(\d+)"`)
 
 type syncReq struct {
   *Bot
 }
 
+// 登录成功，开始synccheck/sync，
+// 在sync之前插入的自定义阶段都执行成功之后才算登录成功
 func (r *syncReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  // 登录过程中可能已经调用了Stop
+  if !r.setState(StateRunning) {
+    r.signInFailed(StageSync, ErrInvalidState)
+    return
+  }
+  r.StartTime = r.now()
+  r.mu.Lock()
+  r.health.lastSyncCheck = r.StartTime
+  r.mu.Unlock()
+  if e := r.saveSession(); e != nil {
+    r.logger.Printf("wxweb: save session failed: %v", e)
+  }
+  r.seen = newSeenSet(r.dedupPolicy)
+  if e := r.seen.load(r.StartTime); e != nil {
+    r.logger.Printf("wxweb: load seen messages failed: %v", e)
+  }
+  r.startDispatcher()
+  r.onSignIn(nil)
   // syncCheck一直执行，有消息时才会执行sync，
   // web微信syncCheck的时间间隔约为25秒左右，
   // 即在没有新消息的时候，服务器会保持（阻塞）连接25秒左右
-  ch := make(chan int)
-  syncCheckChan := make(chan struct{})
-  syncChan := make(chan syncCheckResp)
-  go r.bridge(ch, syncCheckChan, syncChan)
-  go r.syncCheck(ch, syncCheckChan, syncChan)
-  go r.sync(ch, syncCheckChan, syncChan)
-  syncCheckChan <- struct{}{}
+  if !r.spawn(r.loop) {
+    // 已经调用了Stop，loop不会执行
+    r.signOut()
+  }
+  r.spawn(r.watchdog)
   ctx.Fire(val)
 }
 
-func (r *syncReq) bridge(ch chan int, syncCheckChan chan struct{}, syncChan chan syncCheckResp) {
-  for i := range ch {
-    if i == 0 {
-      syncCheckChan <- struct{}{}
-    } else if i == 1 {
-      syncChan <- syncCheckResp{}
-    } else {
-      close(syncCheckChan)
-      close(syncChan)
-      break
+func (r *syncReq) loop() {
+  // 当前Host连续失败（请求失败，不包括返回了retcode）的次数，
+  // 达到syncCheckHostFailures时切换到备用Host
+  failures := 0
+  for {
+    if r.ctx.Err() != nil {
+      r.signOut()
+      return
     }
-  }
-  close(ch)
-}
-
-func (r *syncReq) syncCheck(ch chan int, syncCheckChan chan struct{}, syncChan chan syncCheckResp) {
-  for range syncCheckChan {
-    resp, e := r.doSyncCheck()
+    ctx, cancel := r.pollContext()
+    // cancel之后ctx.Err()总是不为nil，是否被watchdog取消要用pollStalled判断
+    resp, e := r.doSyncCheck(ctx)
+    cancel()
     if e != nil {
-      ch <- 0
+      if r.ctx.Err() != nil {
+        continue
+      }
+      if r.pollStalled() {
+        // 被watchdog取消了（synccheck卡住）
+        if r.reconnect(0) {
+          continue
+        }
+        r.shutdown(ErrStalled)
+        r.signOut()
+        return
+      }
+      failures++
+      r.logger.Printf("wxweb: synccheck failed: %v", e)
+      if failures >= syncCheckHostFailures {
+        failures = 0
+        r.nextSyncCheckHost()
+        r.logger.Printf("wxweb: switch synccheck host to %s", r.session.SyncCheckHost)
+      }
+      if !r.backoff(true, e) {
+        r.signOut()
+        return
+      }
       continue
     }
+    failures = 0
+    r.markSyncCheck(resp.selector)
+    // 返回了retcode说明Host是通的（如1101是已经下线），不需要切换Host
     if resp.code != 0 {
-      ch <- -1
-      r.Stop()
-      r.handler.OnSignOut()
-      break
+      r.logger.Printf("wxweb: synccheck retcode %d", resp.code)
+      if r.reconnect(resp.code) {
+        continue
+      }
+      e = syncCheckError(resp.code)
+      if e == ErrSignedOut {
+        // 已经在手机上退出，保存的凭据不能再使用
+        r.removeSession()
+      }
+      r.shutdown(e)
+      r.signOut()
+      return
     }
     if resp.selector == 0 {
-      ch <- 0
+      r.resetBackoff()
+      continue
+    }
+    if resp.selector != SelectorMessage {
+      r.dispatcher.post(&event{kind: eventSelector, selector: resp.selector})
+    }
+    if e = r.sync(); e != nil {
+      if r.ctx.Err() != nil {
+        continue
+      }
+      r.logger.Printf("wxweb: sync failed: %v", e)
+      if !r.backoff(false, e) {
+        r.signOut()
+        return
+      }
       continue
     }
-    syncChan <- resp
+    r.resetBackoff()
   }
 }
 
-func (r *syncReq) doSyncCheck() (syncCheckResp, error) {
+// webwxsync，ContinueFlag不为0时继续，直到没有数据，
+// SyncKey变化后保存凭据（恢复登录时使用旧的SyncKey会收到重复的消息）
+func (r *syncReq) sync() error {
+  sk := r.session.SyncKey.expand()
+  defer func() {
+    if r.session.SyncKey.expand() == sk {
+      return
+    }
+    if e := r.saveSession(); e != nil {
+      r.logger.Printf("wxweb: save session failed: %v", e)
+    }
+  }()
+  for i := 0; i < syncContinueMax; i++ {
+    data, e := r.doSync()
+    if e != nil {
+      return e
+    }
+    if r.dispatch(data) == 0 {
+      return nil
+    }
+  }
+  r.logger.Printf("wxweb: sync ContinueFlag still set after %d rounds", syncContinueMax)
+  return nil
+}
+
+// 退出循环（熔断、ctx取消等）时回调OnSignOut，
+// 下线的原因已经由shutdown记录，
+// 队列中剩下的事件都回调完之后才回调OnSignOut
+func (r *syncReq) signOut() {
+  r.shutdown(r.ctx.Err())
+  r.dispatcher.close()
+  if e := r.seen.save(); e != nil {
+    r.logger.Printf("wxweb: save seen messages failed: %v", e)
+  }
+  r.onSignOut()
+}
+
+func (r *syncReq) doSyncCheck(ctx context.Context) (syncCheckResp, error) {
   addr, _ := url.Parse(fmt.Sprintf("https://%s/cgi-bin/mmwebwx-bin%s", r.session.SyncCheckHost, syncCheckUrlPath))
   q := addr.Query()
   q.Set("deviceid", deviceId())
   q.Set("r", timestampString13())
   q.Set("sid", r.session.Sid)
   q.Set("skey", r.session.SKey)
-  q.Set("synckey", r.session.SyncKey.expand())
+  q.Set("synckey", r.session.SyncCheckKey.expand())
   q.Set("uin", strconv.FormatInt(r.session.Uin, 10))
   q.Set("_", timestampString13())
   addr.RawQuery = q.Encode()
-  req, _ := http.NewRequest("GET", addr.String(), nil)
+  req, _ := http.NewRequestWithContext(ctx, "GET", addr.String(), nil)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return syncCheckResp{}, e
   }
@@ -98,19 +214,7 @@ func (r *syncReq) doSyncCheck() (syncCheckResp, error) {
   if resp.StatusCode != http.StatusOK {
     return syncCheckResp{}, ErrReq
   }
-  return parseSyncCheckResp(resp)
-}
-
-func (r *syncReq) sync(ch chan int, syncCheckChan chan struct{}, syncChan chan syncCheckResp) {
-  for syncCheck := range syncChan {
-    data, e := r.doSync()
-    if e != nil {
-      syncCheckChan <- struct{}{}
-      continue
-    }
-    r.dispatch(syncCheck, data)
-    syncCheckChan <- struct{}{}
-  }
+  return r.parseSyncCheckResp(resp)
 }
 
 func (r *syncReq) doSync() ([]byte, error) {
@@ -125,11 +229,11 @@ func (r *syncReq) doSync() ([]byte, error) {
   m["rr"] = strconv.FormatInt(^(time2.Timestamp() / int64(time.Second)), 10)
   m["SyncKey"] = r.session.SyncKey
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
   req.Header.Set("Content-Type", contentType)
   req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
-  resp, e := r.client.Do(req)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -141,11 +245,11 @@ func (r *syncReq) doSync() ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("7_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5)+"_sync", body)
+  r.dump("7_"+r.now().Format(time2.DateTimeFormatMs5)+"_sync", body)
   return body, nil
 }
 
-func parseSyncCheckResp(resp *http.Response) (syncCheckResp, error) {
+func (bot *Bot) parseSyncCheckResp(resp *http.Response) (syncCheckResp, error) {
   // window.synccheck={retcode:"0",selector:"2"}
   // retcode=0：正常，
   // retcode=1100：退出（原因未知），
@@ -155,7 +259,7 @@ func parseSyncCheckResp(resp *http.Response) (syncCheckResp, error) {
   // selector=2：有新消息，
   // selector=4：新增或删除联系人/保存群到通讯录/修改群名称/群成员数目变化，
   // selector=5：未知，
-  // selector=6：未知，
+  // selector=6：未知（可能是有新增或删除的好友），
   // selector=7：操作了手机（如进入/关闭聊天页面）
   body, e := ioutil.ReadAll(resp.Body)
   if e != nil {
@@ -164,7 +268,7 @@ func parseSyncCheckResp(resp *http.Response) (syncCheckResp, error) {
   data := string(body)
   arr := syncCheckRegex.FindStringSubmatch(data)
   if len(arr) < 2 {
-    dump("7_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5)+"_check", body)
+    bot.dump("7_"+bot.now().Format(time2.DateTimeFormatMs5)+"_check", body)
     return syncCheckResp{}, ErrResp
   }
   ret := syncCheckResp{}
@@ -173,11 +277,18 @@ func parseSyncCheckResp(resp *http.Response) (syncCheckResp, error) {
     ret.selector, _ = strconv.Atoi(arr[2])
   }
   if ret.code != 0 || ret.selector != 0 {
-    dump("7_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5)+"_check", body)
+    bot.dump("7_"+bot.now().Format(time2.DateTimeFormatMs5)+"_check", body)
   }
   return ret, nil
 }
 
+func syncCheckError(code int) error {
+  if e, ok := retErrors[code]; ok {
+    return e
+  }
+  return fmt.Errorf("synccheck retcode %d", code)
+}
+
 type syncCheckResp struct {
   code     int
   selector int
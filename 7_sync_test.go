@@ -0,0 +1,175 @@
+package wxweb
+
+import (
+  "errors"
+  "net/http"
+  "path"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+// 普通的请求失败应该退避并最终熔断，而不是当作watchdog超时去重连
+func TestLoopTransportErrorBacksOff(t *testing.T) {
+  bot := newTestBot(t, failingTransport())
+  h := &testHandler{}
+  startTestLoop(t, bot, h)
+  waitDone(t, bot)
+
+  if !errors.Is(bot.Err(), ErrCircuitOpen) {
+    t.Fatalf("Err() = %v, want ErrCircuitOpen", bot.Err())
+  }
+  if errors.Is(bot.Err(), ErrStalled) {
+    t.Fatalf("Err() = %v, should not be ErrStalled", bot.Err())
+  }
+  if n := bot.SyncErrors().SyncCheck; n != 3 {
+    t.Errorf("SyncErrors().SyncCheck = %d, want 3", n)
+  }
+  if len(h.reconnecting) != 0 {
+    t.Errorf("reconnecting = %v, want none", h.reconnecting)
+  }
+  if h.signOut != 1 {
+    t.Errorf("signOut = %d, want 1", h.signOut)
+  }
+}
+
+// 在回调和其他goroutine中发消息的同时，sync会更新SyncKey和头像等，需要用go test -race运行
+func TestLoopConcurrentSenders(t *testing.T) {
+  syncBody := `{"BaseResponse":{"Ret":0},` +
+    `"AddMsgList":[{"MsgId":"1","FromUserName":"@a","ToUserName":"@self","MsgType":1,"Content":"hi"}],` +
+    `"SyncKey":{"Count":1,"List":[{"Key":1,"Val":2}]},` +
+    `"SyncCheckKey":{"Count":1,"List":[{"Key":1,"Val":2}]},` +
+    `"Profile":{"BitFlag":190,"NickName":{"Buff":"self"},"HeadImgUpdateFlag":1,"HeadImgUrl":"/avatar"},` +
+    `"ContinueFlag":0}`
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "synccheck":    `window.synccheck={retcode:"0",selector:"2"}`,
+    "webwxsync":    syncBody,
+    "webwxsendmsg": `{"BaseResponse":{"Ret":0},"MsgID":"2","LocalID":"2"}`,
+    "avatar":       "jpg",
+  }))
+  dir := tempDir(t)
+  bot.contacts.Add(&Contact{bot: bot, UserName: "@a", NickName: "a"})
+  h := &testHandler{}
+  startTestLoop(t, bot, h)
+
+  stop := make(chan struct{})
+  var wg sync.WaitGroup
+  for i := 0; i < 4; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for {
+        select {
+        case <-stop:
+          return
+        default:
+        }
+        bot.SendText("@a", "hello")
+        bot.DownloadAvatar(path.Join(dir, "avatar.jpg"))
+        bot.DispatchStats()
+        bot.Self()
+      }
+    }()
+  }
+  deadline := time.Now().Add(time.Second * 5)
+  for bot.DispatchStats().Dispatched == 0 && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+  }
+  time.Sleep(time.Millisecond * 50)
+  close(stop)
+  wg.Wait()
+  bot.Stop()
+  waitDone(t, bot)
+  if bot.sessionCopy().AvatarUrl == "" {
+    t.Error("AvatarUrl not updated")
+  }
+  if len(h.messages) != 1 {
+    t.Errorf("got %d messages, want 1", len(h.messages))
+  }
+}
+
+// 只有请求失败时才切换备用的synccheck Host，返回了retcode（如1101）时不切换
+func TestLoopSyncCheckHostSwitch(t *testing.T) {
+  tests := []struct {
+    name      string
+    body      string
+    failures  int
+    wantErr   error
+    wantHosts int
+  }{
+    {"signed out", `window.synccheck={retcode:"1101",selector:"0"}`, 3, ErrSignedOut, 1},
+    {"transport error", "", syncCheckHostFailures * 2, ErrCircuitOpen, 2},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      var mu sync.Mutex
+      hosts := make(map[string]bool)
+      rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+        mu.Lock()
+        hosts[req.URL.Host] = true
+        mu.Unlock()
+        if tt.body == "" {
+          return nil, errTestTransport
+        }
+        return stubTransport(map[string]string{"synccheck": tt.body}).RoundTrip(req)
+      })
+      bot := newTestBot(t, rt, WithBackoffPolicy(BackoffPolicy{Min: time.Millisecond, Max: time.Millisecond, MaxFailures: tt.failures}))
+      h := &testHandler{}
+      startTestLoop(t, bot, h)
+      waitDone(t, bot)
+      if !errors.Is(bot.Err(), tt.wantErr) {
+        t.Errorf("Err() = %v, want %v", bot.Err(), tt.wantErr)
+      }
+      mu.Lock()
+      defer mu.Unlock()
+      if len(hosts) != tt.wantHosts {
+        t.Errorf("synccheck hosts = %v, want %d", hosts, tt.wantHosts)
+      }
+    })
+  }
+}
+
+// 请求一直失败时由退避和熔断处理，watchdog不能当作卡住而提前下线
+func TestWatchdogIgnoresBackoff(t *testing.T) {
+  bot := newTestBot(t, failingTransport(),
+    WithBackoffPolicy(BackoffPolicy{Min: time.Millisecond * 5, Max: time.Millisecond * 5, MaxFailures: 30}),
+    WithWatchdogTimeout(time.Millisecond*20))
+  h := &testHandler{}
+  startTestLoop(t, bot, h)
+  bot.spawn(bot.watchdog)
+  waitDone(t, bot)
+  if !errors.Is(bot.Err(), ErrCircuitOpen) {
+    t.Fatalf("Err() = %v, want ErrCircuitOpen", bot.Err())
+  }
+  if n := bot.SyncErrors().SyncCheck; n != 30 {
+    t.Errorf("SyncErrors().SyncCheck = %d, want 30", n)
+  }
+  if len(h.reconnecting) != 0 {
+    t.Errorf("reconnecting = %v, want none", h.reconnecting)
+  }
+}
+
+// synccheck卡住（一直不返回）时watchdog取消它并重连，重连失败则下线
+func TestWatchdogCancelsStalledPoll(t *testing.T) {
+  rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+    if !strings.HasSuffix(req.URL.Path, "/synccheck") {
+      return nil, errTestTransport
+    }
+    <-req.Context().Done()
+    return nil, req.Context().Err()
+  })
+  bot := newTestBot(t, rt,
+    WithWatchdogTimeout(time.Millisecond*20),
+    WithReconnectPolicy(ReconnectPolicy{MaxAttempts: 1}))
+  h := &testHandler{}
+  startTestLoop(t, bot, h)
+  bot.spawn(bot.watchdog)
+  waitDone(t, bot)
+  if !errors.Is(bot.Err(), ErrStalled) {
+    t.Fatalf("Err() = %v, want ErrStalled", bot.Err())
+  }
+  if len(h.reconnecting) != 1 || h.reconnecting[0] != 0 {
+    t.Errorf("reconnecting = %v, want [0]", h.reconnecting)
+  }
+}
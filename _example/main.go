@@ -3,17 +3,12 @@ package main
 import (
   "bytes"
   "log"
-  "os/exec"
-  "runtime"
-  "sync"
-  "time"
+  "os"
 
   "github.com/kwf2030/commons/time2"
   "github.com/kwf2030/wxweb"
 )
 
-var wg sync.WaitGroup
-
 type Handler struct {
   bot *wxweb.Bot
 }
@@ -34,26 +29,19 @@ func (h *Handler) OnSignOut() {
   buf.WriteString("sign in: %s\n")
   buf.WriteString("sign out: %s\n")
   buf.WriteString("online for %.2f hours\n")
+  buf.WriteString("reason: %v\n")
   log.Printf(buf.String(), h.bot.Self().NickName,
     h.bot.StartTime.Format(time2.DateTimeFormat),
     h.bot.StopTime.Format(time2.DateTimeFormat),
-    h.bot.StopTime.Sub(h.bot.StartTime).Hours())
-  h.bot.Release()
-  wg.Done()
+    h.bot.StopTime.Sub(h.bot.StartTime).Hours(),
+    h.bot.Err())
 }
 
 // 二维码回调，需要扫码登录，
-// qrCodeUrl是二维码的链接
+// qrCodeUrl是二维码的链接，
+// 这里直接在终端输出二维码（也可以用QRCodePNG生成图片）
 func (h *Handler) OnQRCode(qrcodeUrl string) {
-  p, _ := h.bot.DownloadQRCode("")
-  switch runtime.GOOS {
-  case "windows":
-    exec.Command("cmd.exe", "/c", p).Start()
-  case "linux":
-    exec.Command("eog", p).Start()
-  default:
-    log.Printf("qr code is saved to [%s], open it and scan for sign in\n", p)
-  }
+  h.bot.WriteQRCodeTerminal(os.Stdout)
 }
 
 func (h *Handler) OnContact(c *wxweb.Contact, _ int) {
@@ -111,9 +99,14 @@ func (h *Handler) reply(msg *wxweb.Message) {
 
 func main() {
   wxweb.EnableDump(true)
-  bot := wxweb.New()
+  // 保存登录凭据，重启后不需要重新扫码
+  bot := wxweb.New(
+    wxweb.WithSessionStore(wxweb.NewFileSessionStore("wxweb/session.json")),
+    wxweb.WithLogger(log.New(os.Stderr, "", log.LstdFlags)),
+  )
   bot.Start(&Handler{bot: bot})
-  wg.Add(1)
-  wg.Wait()
-  time.Sleep(time.Second)
+  // 等待下线（登录失败也会返回），
+  // Release不能在回调（如OnSignOut）中调用，回调返回之前Wait不会返回
+  bot.Wait()
+  bot.Release()
 }
@@ -0,0 +1,105 @@
+package wxweb
+
+import (
+  "encoding/xml"
+  "errors"
+  "html"
+  "strings"
+
+  "github.com/buger/jsonparser"
+)
+
+// 链接消息（MsgLink）的AppMsgType
+const (
+  AppMsgText        = 1
+  AppMsgImage       = 2
+  AppMsgMusic       = 3
+  AppMsgVideo       = 4
+  AppMsgUrl         = 5
+  AppMsgFile        = 6
+  AppMsgEmoji       = 8
+  AppMsgMiniProgram = 33
+  AppMsgQuote       = 57
+  AppMsgTransfer    = 2000
+  AppMsgRedEnvelope = 2001
+)
+
+var ErrNotAppMsg = errors.New("not an app message")
+
+// 链接消息的内容（Content中<appmsg>节点）
+type AppMsg struct {
+  AppMsgType int
+
+  Title string
+  Des   string
+  Url   string
+
+  // 文件的扩展名和大小（AppMsgFile）
+  FileExt  string
+  TotalLen int64
+
+  // 附件Id（Content中），MediaId（消息中，下载文件时使用）
+  AttachId string
+  MediaId  string
+
+  ThumbUrl string
+
+  // 来源（公众号名称、小程序名称等）
+  SourceDisplayName string
+
+  // 原始的<appmsg>节点
+  Raw string
+}
+
+type appMsgXML struct {
+  XMLName           xml.Name `xml:"appmsg"`
+  Type              int      `xml:"type"`
+  Title             string   `xml:"title"`
+  Des               string   `xml:"des"`
+  Url               string   `xml:"url"`
+  FileExt           string   `xml:"appattach>fileext"`
+  TotalLen          int64    `xml:"appattach>totallen"`
+  AttachId          string   `xml:"appattach>attachid"`
+  ThumbUrl          string   `xml:"thumburl"`
+  SourceDisplayName string   `xml:"sourcedisplayname"`
+}
+
+// 解析链接消息（MsgLink），其他类型的消息返回ErrNotAppMsg
+func (msg *Message) AppMsg() (*AppMsg, error) {
+  if msg.Type != MsgLink {
+    return nil, ErrNotAppMsg
+  }
+  // Content是转义过的XML，换行是<br/>，群消息可能还有发送人前缀
+  content := html.UnescapeString(strings.ReplaceAll(msg.Content, "<br/>", "\n"))
+  i := strings.Index(content, "<appmsg")
+  j := strings.LastIndex(content, "</appmsg>")
+  if i < 0 || j < i {
+    return nil, ErrNotAppMsg
+  }
+  node := content[i : j+len("</appmsg>")]
+  v := &appMsgXML{}
+  if e := xml.Unmarshal([]byte(node), v); e != nil {
+    return nil, e
+  }
+  ret := &AppMsg{
+    AppMsgType:        v.Type,
+    Title:             v.Title,
+    Des:               v.Des,
+    Url:               v.Url,
+    FileExt:           v.FileExt,
+    TotalLen:          v.TotalLen,
+    AttachId:          v.AttachId,
+    ThumbUrl:          v.ThumbUrl,
+    SourceDisplayName: v.SourceDisplayName,
+    Raw:               node,
+  }
+  if ret.AppMsgType == 0 {
+    t, _ := jsonparser.GetInt(msg.raw, "AppMsgType")
+    ret.AppMsgType = int(t)
+  }
+  ret.MediaId, _ = jsonparser.GetString(msg.raw, "MediaId")
+  if ret.Url == "" {
+    ret.Url = msg.Url
+  }
+  return ret, nil
+}
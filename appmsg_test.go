@@ -0,0 +1,49 @@
+package wxweb
+
+import (
+  "testing"
+)
+
+func TestMessageAppMsg(t *testing.T) {
+  file := `&lt;msg&gt;&lt;appmsg appid="" sdkver="0"&gt;&lt;title&gt;report.pdf&lt;/title&gt;&lt;des&gt;&lt;/des&gt;` +
+    `&lt;type&gt;6&lt;/type&gt;&lt;appattach&gt;&lt;totallen&gt;1024&lt;/totallen&gt;&lt;attachid&gt;@cdn_1&lt;/attachid&gt;` +
+    `&lt;fileext&gt;pdf&lt;/fileext&gt;&lt;/appattach&gt;&lt;/appmsg&gt;&lt;/msg&gt;`
+  link := `@abc:<br/>&lt;msg&gt;&lt;appmsg&gt;&lt;title&gt;News &amp;amp; more&lt;/title&gt;&lt;des&gt;line1&lt;/des&gt;` +
+    `&lt;type&gt;5&lt;/type&gt;&lt;url&gt;https://example.com/a?b=1&amp;amp;c=2&lt;/url&gt;` +
+    `&lt;thumburl&gt;https://example.com/t.jpg&lt;/thumburl&gt;&lt;sourcedisplayname&gt;src&lt;/sourcedisplayname&gt;&lt;/appmsg&gt;&lt;/msg&gt;`
+  noType := `&lt;msg&gt;&lt;appmsg&gt;&lt;title&gt;t&lt;/title&gt;&lt;/appmsg&gt;&lt;/msg&gt;`
+  tests := []struct {
+    name    string
+    typ     int
+    content string
+    raw     string
+    url     string
+    want    *AppMsg
+    wantErr error
+  }{
+    {"not link", MsgText, file, `{}`, "", nil, ErrNotAppMsg},
+    {"no appmsg", MsgLink, "hello", `{}`, "", nil, ErrNotAppMsg},
+    {"file", MsgLink, file, `{"MediaId":"m1"}`, "", &AppMsg{AppMsgType: AppMsgFile, Title: "report.pdf", FileExt: "pdf", TotalLen: 1024, AttachId: "@cdn_1", MediaId: "m1"}, nil},
+    {"group link", MsgLink, link, `{}`, "", &AppMsg{AppMsgType: AppMsgUrl, Title: "News & more", Des: "line1", Url: "https://example.com/a?b=1&c=2", ThumbUrl: "https://example.com/t.jpg", SourceDisplayName: "src"}, nil},
+    {"type from message", MsgLink, noType, `{"AppMsgType":33}`, "https://example.com", &AppMsg{AppMsgType: AppMsgMiniProgram, Title: "t", Url: "https://example.com"}, nil},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      msg := &Message{Type: tt.typ, Content: tt.content, Url: tt.url, raw: []byte(tt.raw)}
+      got, e := msg.AppMsg()
+      if e != tt.wantErr {
+        t.Fatalf("AppMsg() error = %v, want %v", e, tt.wantErr)
+      }
+      if tt.want == nil {
+        return
+      }
+      if got.Raw == "" {
+        t.Error("Raw is empty")
+      }
+      got.Raw = ""
+      if *got != *tt.want {
+        t.Errorf("AppMsg() = %+v, want %+v", got, tt.want)
+      }
+    })
+  }
+}
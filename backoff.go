@@ -0,0 +1,154 @@
+package wxweb
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "math/rand"
+  "time"
+)
+
+// synccheck/sync连续失败次数达到BackoffPolicy.MaxFailures后下线，Err返回*CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit open")
+
+// synccheck/sync失败后的重试策略，
+// 每次失败后等待的时间按Factor指数增长（不超过Max），并加上随机抖动，
+// 连续失败MaxFailures次后熔断（下线）
+type BackoffPolicy struct {
+  // 第一次失败后等待的时间，默认1秒
+  Min time.Duration
+
+  // 最长等待时间，默认2分钟
+  Max time.Duration
+
+  // 增长倍数，默认2
+  Factor float64
+
+  // 随机抖动的比例（0~1），默认0.2，即在等待时间的±20%内随机
+  Jitter float64
+
+  // 连续失败多少次后熔断，0表示不熔断
+  MaxFailures int
+}
+
+var defaultBackoffPolicy = BackoffPolicy{
+  Min:         time.Second,
+  Max:         time.Minute * 2,
+  Factor:      2,
+  Jitter:      0.2,
+  MaxFailures: 30,
+}
+
+func (bot *Bot) SetBackoffPolicy(policy BackoffPolicy) {
+  if policy.Min <= 0 {
+    policy.Min = defaultBackoffPolicy.Min
+  }
+  if policy.Max < policy.Min {
+    policy.Max = policy.Min
+  }
+  if policy.Factor < 1 {
+    policy.Factor = defaultBackoffPolicy.Factor
+  }
+  if policy.Jitter < 0 || policy.Jitter > 1 {
+    policy.Jitter = defaultBackoffPolicy.Jitter
+  }
+  bot.backoffPolicy = policy
+}
+
+// 第n次（从1开始）连续失败后等待的时间
+func (p *BackoffPolicy) duration(n int) time.Duration {
+  if n <= 0 {
+    return 0
+  }
+  d := float64(p.Min) * math.Pow(p.Factor, float64(n-1))
+  if d > float64(p.Max) {
+    d = float64(p.Max)
+  }
+  if p.Jitter > 0 {
+    d += d * p.Jitter * (rand.Float64()*2 - 1)
+  }
+  return time.Duration(d)
+}
+
+// 熔断时下线的原因
+type CircuitOpenError struct {
+  // 连续失败的次数
+  Failures int
+
+  // 最后一次失败的原因
+  Last error
+}
+
+func (e *CircuitOpenError) Error() string {
+  return fmt.Sprintf("circuit open after %d consecutive failures: %v", e.Failures, e.Last)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+  return target == ErrCircuitOpen
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+  return e.Last
+}
+
+// synccheck/sync的错误统计
+type SyncErrors struct {
+  // synccheck失败的总次数
+  SyncCheck int
+
+  // sync失败的总次数
+  Sync int
+
+  // 当前连续失败的次数（成功后清零）
+  Consecutive int
+
+  // 最后一次失败的原因和时间
+  Last     error
+  LastTime time.Time
+}
+
+// synccheck/sync的错误统计（返回的是副本）
+func (bot *Bot) SyncErrors() SyncErrors {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  return bot.syncErrors
+}
+
+// 记录一次失败并等待（退避），
+// 返回false表示已经熔断或ctx已取消，需要退出循环
+func (bot *Bot) backoff(syncCheck bool, err error) bool {
+  bot.mu.Lock()
+  if syncCheck {
+    bot.syncErrors.SyncCheck++
+  } else {
+    bot.syncErrors.Sync++
+  }
+  bot.syncErrors.Consecutive++
+  bot.syncErrors.Last = err
+  bot.syncErrors.LastTime = bot.now()
+  n := bot.syncErrors.Consecutive
+  bot.mu.Unlock()
+
+  p := bot.backoffPolicy
+  if p.MaxFailures > 0 && n >= p.MaxFailures {
+    bot.shutdown(&CircuitOpenError{Failures: n, Last: err})
+    return false
+  }
+  d := p.duration(n)
+  bot.logger.Printf("wxweb: %d consecutive failures, retry in %v: %v", n, d, err)
+  t := time.NewTimer(d)
+  defer t.Stop()
+  select {
+  case <-bot.ctx.Done():
+    return false
+  case <-t.C:
+    return true
+  }
+}
+
+// 成功后清零连续失败的次数
+func (bot *Bot) resetBackoff() {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.syncErrors.Consecutive = 0
+}
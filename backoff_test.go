@@ -0,0 +1,99 @@
+package wxweb
+
+import (
+  "errors"
+  "testing"
+  "time"
+)
+
+func TestBackoffPolicyDuration(t *testing.T) {
+  p := BackoffPolicy{Min: time.Second, Max: time.Second * 10, Factor: 2}
+  tests := []struct {
+    n    int
+    want time.Duration
+  }{
+    {0, 0},
+    {1, time.Second},
+    {2, time.Second * 2},
+    {4, time.Second * 8},
+    {5, time.Second * 10},
+    {100, time.Second * 10},
+  }
+  for _, tt := range tests {
+    if got := p.duration(tt.n); got != tt.want {
+      t.Errorf("duration(%d) = %v, want %v", tt.n, got, tt.want)
+    }
+  }
+  p.Jitter = 0.2
+  for i := 0; i < 100; i++ {
+    if d := p.duration(3); d < time.Millisecond*3200 || d > time.Millisecond*4800 {
+      t.Fatalf("duration(3) with jitter = %v, want 4s±20%%", d)
+    }
+  }
+}
+
+func TestSetBackoffPolicy(t *testing.T) {
+  tests := []struct {
+    name string
+    in   BackoffPolicy
+    want BackoffPolicy
+  }{
+    {"zero", BackoffPolicy{}, BackoffPolicy{Min: time.Second, Max: time.Second, Factor: 2, Jitter: 0}},
+    {"max below min", BackoffPolicy{Min: time.Second * 5, Max: time.Second, Factor: 3}, BackoffPolicy{Min: time.Second * 5, Max: time.Second * 5, Factor: 3}},
+    {"invalid jitter", BackoffPolicy{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: 2, MaxFailures: 5}, BackoffPolicy{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: 0.2, MaxFailures: 5}},
+  }
+  for _, tt := range tests {
+    bot := newTestBot(t, failingTransport())
+    bot.SetBackoffPolicy(tt.in)
+    if bot.backoffPolicy != tt.want {
+      t.Errorf("%s: SetBackoffPolicy() = %+v, want %+v", tt.name, bot.backoffPolicy, tt.want)
+    }
+  }
+}
+
+func TestBackoffCircuitBreaker(t *testing.T) {
+  tests := []struct {
+    name        string
+    maxFailures int
+    fails       int
+    reset       bool
+    wantOpen    bool
+  }{
+    {"below threshold", 3, 2, false, false},
+    {"open", 3, 3, false, true},
+    {"reset in between", 3, 3, true, false},
+    {"disabled", 0, 10, false, false},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      bot := newTestBot(t, failingTransport(), WithBackoffPolicy(BackoffPolicy{Min: time.Microsecond, Max: time.Microsecond, MaxFailures: tt.maxFailures}))
+      open := false
+      for i := 0; i < tt.fails; i++ {
+        if !bot.backoff(i%2 == 0, errTestTransport) {
+          open = true
+          break
+        }
+        if tt.reset && i == 0 {
+          bot.resetBackoff()
+        }
+      }
+      if open != tt.wantOpen {
+        t.Fatalf("circuit open = %v, want %v", open, tt.wantOpen)
+      }
+      se := bot.SyncErrors()
+      if se.SyncCheck+se.Sync != tt.fails || se.Last != errTestTransport {
+        t.Errorf("SyncErrors() = %+v", se)
+      }
+      if !tt.wantOpen {
+        return
+      }
+      var ce *CircuitOpenError
+      if !errors.As(bot.Err(), &ce) || ce.Failures != tt.maxFailures {
+        t.Fatalf("Err() = %v, want *CircuitOpenError", bot.Err())
+      }
+      if !errors.Is(bot.Err(), ErrCircuitOpen) || !errors.Is(bot.Err(), errTestTransport) {
+        t.Errorf("Err() = %v, should match ErrCircuitOpen and the last error", bot.Err())
+      }
+    })
+  }
+}
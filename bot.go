@@ -1,11 +1,11 @@
 package wxweb
 
 import (
+  "context"
   "errors"
   "fmt"
   "net/http"
   "net/http/cookiejar"
-  "os"
   "path"
   "strconv"
   "strings"
@@ -53,14 +53,7 @@ const (
   // 头像存放路径
   attrAvatarPath = "wxweb.avatar_path"
 
-  // 正在登录时用时间戳作为key，保证bots中有记录且可查询这个Bot
-  attrRandUin = "wxweb.rand_uin"
-
-  rootDir = "wxweb"
-  dumpDir = rootDir + "/dump/"
-
   contentType = "application/json; charset=UTF-8"
-  userAgent   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/71.0.3578.98 Safari/537.36"
 )
 
 var (
@@ -73,16 +66,13 @@ var (
   ErrContactNotFound = errors.New("contact not found")
 )
 
-var (
-  dumpEnabled = false
-
-  botsMutex = sync.RWMutex{}
-  bots      = make(map[int64]*Bot, 4)
-)
+var dumpEnabled = false
 
+// OnContact和OnMessage默认在多个goroutine中并发回调（同一个会话的事件按顺序回调），
+// 见DispatchPolicy
 type Handler interface {
   // 登录成功（error == nil），
-  // 登录失败（error != nil）
+  // 登录失败（error != nil，类型为*SignInError）
   OnSignIn(error)
 
   // 退出/下线
@@ -95,7 +85,8 @@ type Handler interface {
   // 联系人更新，如：
   // 好友资料更新、删除好友或被好友删除等，
   // 建群、加入群、被拉入群、群改名、群成员变更、退群或被群主移出群等，
-  // 第二个参数暂时没用
+  // 第二个参数是变化的类型（ContactAdded、ContactModified或ContactDeleted），
+  // 需要变化前的数据和变化的字段可以实现ContactEventHandler
   OnContact(*Contact, int)
 
   // 收到消息，
@@ -103,57 +94,64 @@ type Handler interface {
   OnMessage(*Message, int)
 }
 
-func init() {
-  e := os.MkdirAll(rootDir, os.ModePerm)
-  if e != nil {
-    return
-  }
-  updatePaths()
-}
-
-func updatePaths() {
-  time.AfterFunc(time2.DurationUntilTomorrow(time2.Shanghai()), func() {
-    for _, b := range RunningBots() {
-      b.updatePaths()
-    }
-    updatePaths()
-  })
-}
-
-func EachBot(f func(*Bot) bool) {
-  arr := make([]*Bot, 0, 2)
-  botsMutex.RLock()
-  for _, v := range bots {
-    arr = append(arr, v)
-  }
-  botsMutex.RUnlock()
-  for _, v := range arr {
-    if !f(v) {
-      break
-    }
-  }
-}
-
-func CountBots() int {
-  botsMutex.RLock()
-  defer botsMutex.RUnlock()
-  return len(bots)
-}
-
+// 开启后会把每个请求的响应保存到dump目录（在第一次保存时创建）
 func EnableDump(enabled bool) {
-  if dumpEnabled = enabled; enabled {
-    os.MkdirAll(dumpDir, os.ModePerm)
-  }
+  dumpEnabled = enabled
 }
 
 type Bot struct {
   handler Handler
-
-  client  *http.Client
-  session *session
-  req     *wxReq
+  manager *Manager
+
+  store           SessionStore
+  qrPolicy        QRPolicy
+  reconnectPolicy ReconnectPolicy
+  backoffPolicy   BackoffPolicy
+  dispatchPolicy  DispatchPolicy
+  dedupPolicy     DedupPolicy
+  eventsPolicy    EventsPolicy
+  mediaPolicy     MediaPolicy
+
+  client    *http.Client
+  timeouts  map[string]time.Duration
+  userAgent string
+  appId     string
+  lang      string
+  rootDir   string
+  clock     func() time.Time
+  logger    Logger
+
+  session    *session
+  req        *wxReq
+  dispatcher *dispatcher
+  events     *eventStream
+  seen       *seenSet
+
+  // 只能通过State/setState访问
+  state int32
+
+  ctx    context.Context
+  cancel context.CancelFunc
+  err    error
+
+  syncErrors SyncErrors
+  health     health
+
+  watchdogTimeout time.Duration
+  stopTimeout     time.Duration
+
+  // 正在运行的goroutine（登录、synccheck/sync、watchdog）的数量，
+  // 下线并且都退出后关闭done
+  running    int
+  done       chan struct{}
+  doneClosed bool
+
+  // 每天0点更新存放目录
+  pathsTimer *time.Timer
+  mu         sync.Mutex
 
   signInPipeline *pipeline.Pipeline
+  started        bool
 
   self     *Contact
   contacts *Contacts
@@ -164,103 +162,238 @@ type Bot struct {
   StopTime  time.Time
 }
 
-func New() *Bot {
+func New(opts ...Option) *Bot {
   jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
   s := &session{}
   s.init()
   bot := &Bot{
+    qrPolicy:        defaultQRPolicy,
+    backoffPolicy:   defaultBackoffPolicy,
+    dispatchPolicy:  defaultDispatchPolicy,
+    dedupPolicy:     defaultDedupPolicy,
+    eventsPolicy:    defaultEventsPolicy,
+    watchdogTimeout: defaultWatchdogTimeout,
+    stopTimeout:     defaultStopTimeout,
+    done:            make(chan struct{}),
     client: &http.Client{
-      Jar:     jar,
-      Timeout: time.Minute * 2,
+      Jar:     newCookieJar(jar),
+      Timeout: defaultTimeout,
     },
+    timeouts:       make(map[string]time.Duration, 4),
+    userAgent:      defaultUserAgent,
+    appId:          defaultAppId,
+    lang:           defaultLang,
+    rootDir:        defaultRootDir,
+    clock:          time2.Shanghai,
+    logger:         nopLogger{},
     session:        s,
     signInPipeline: pipeline.New(),
     attr:           &sync.Map{},
   }
+  bot.ctx, bot.cancel = context.WithCancel(context.Background())
+  bot.SetReconnectPolicy(defaultReconnectPolicy)
+  for _, opt := range opts {
+    opt(bot)
+  }
   bot.req = &wxReq{bot}
-  k := time2.Timestamp()
-  bot.attr.Store(attrRandUin, k)
-  botsMutex.Lock()
-  defer botsMutex.Unlock()
-  bots[k] = bot
-  return bot
-}
-
-func GetBotByUUID(uuid string) *Bot {
-  if uuid == "" {
-    return nil
+  bot.signInPipeline.AddLast(StageResume, &resumeReq{bot}).
+    AddLast(StageQR, &qrReq{bot}).
+    AddLast(StageScan, &scanReq{bot}).
+    AddLast(StageRedirect, &redirectReq{bot}).
+    AddLast(StageInit, &initReq{bot}).
+    AddLast(StageNotify, &notifyReq{bot}).
+    AddLast(StageContacts, &contactsReq{bot}).
+    AddLast(StageSync, &syncReq{bot})
+  if bot.manager == nil {
+    bot.manager = defaultManager
   }
-  var ret *Bot
-  EachBot(func(b *Bot) bool {
-    if b.session.UUID == uuid {
-      ret = b
-      return false
-    }
-    return true
-  })
-  return ret
-}
-
-func GetBotByUin(uin int64) *Bot {
-  var ret *Bot
-  EachBot(func(b *Bot) bool {
-    if b.session.Uin == uin {
-      ret = b
-      return false
-    }
-    return true
-  })
-  return ret
+  bot.manager.Add(bot)
+  return bot
 }
 
-func RunningBots() []*Bot {
-  ret := make([]*Bot, 0, 4)
-  botsMutex.RLock()
-  defer botsMutex.RUnlock()
-  for _, v := range bots {
-    if v.session.State == StateRunning {
-      ret = append(ret, v)
-    }
-  }
-  return ret
+// Bot所在的Manager
+func (bot *Bot) Manager() *Manager {
+  return bot.manager
 }
 
 func (bot *Bot) Self() *Contact {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
   return bot.self
 }
 
+func (bot *Bot) setSelf(c *Contact) {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.self = c
+}
+
+// 登录成功（获取联系人）之前为nil
 func (bot *Bot) Contacts() *Contacts {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
   return bot.contacts
 }
 
+func (bot *Bot) setContacts(cs *Contacts) {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.contacts = cs
+}
+
+// 开始登录（阻塞直到登录成功或失败），
+// handler可以为nil（只使用Events）
 func (bot *Bot) Start(handler Handler) {
-  if handler == nil {
+  bot.StartContext(context.Background(), handler)
+}
+
+// 取消ctx会停止登录（OnSignIn收到ctx.Err()）或下线（OnSignOut，Err返回ctx.Err()），
+// 取消时不会调用webwxlogout，保存的凭据仍然可以用来恢复登录
+func (bot *Bot) StartContext(ctx context.Context, handler Handler) {
+  // 已下线的Bot不能再启动，需要重新创建
+  if ctx == nil || bot.State() == StateStop {
     return
   }
+  // 只使用Events时可以不传Handler
+  if handler == nil {
+    handler = nopHandler{}
+  }
+  bot.mu.Lock()
+  bot.ctx, bot.cancel = context.WithCancel(ctx)
   bot.handler = handler
-  bot.signInPipeline.AddLast("qr", &qrReq{bot}).
-    AddLast("scan", &scanReq{bot}).
-    AddLast("redirect", &redirectReq{bot}).
-    AddLast("init", &initReq{bot}).
-    AddLast("notify", &notifyReq{bot}).
-    AddLast("contacts", &contactsReq{bot}).
-    AddLast("sync", &syncReq{bot})
-  bot.signInPipeline.Fire(nil)
-  if k, ok := bot.attr.Load(attrRandUin); ok {
-    botsMutex.Lock()
-    defer botsMutex.Unlock()
-    delete(bots, k.(int64))
+  bot.started = true
+  bot.mu.Unlock()
+  if !bot.enter() {
+    return
   }
+  bot.signInPipeline.Fire(nil)
+  bot.leave()
 }
 
+// 退出登录（会删除SessionStore中保存的凭据），会停止synccheck/sync并回调OnSignOut，
+// 然后等待正在执行的回调返回（最多等待StopTimeout），
+// 在回调中调用时会一直等到超时，可以用go bot.Stop()
 func (bot *Bot) Stop() {
-  bot.StopTime = time2.Shanghai()
-  bot.session.State = StateStop
-  bot.req.SignOut()
+  if bot.State() == StateStop {
+    return
+  }
+  signedOut := false
+  if bot.State() == StateRunning {
+    bot.req.SignOut()
+    signedOut = true
+  }
+  bot.shutdown(nil)
+  t := time.NewTimer(bot.stopTimeout)
+  defer t.Stop()
+  select {
+  case <-bot.done:
+  case <-t.C:
+    bot.logger.Printf("wxweb: stop timeout, callbacks still running")
+  }
+  // 已退出登录，保存的凭据不能再使用，
+  // 在sync退出之后删除，否则可能又被保存
+  if signedOut {
+    bot.removeSession()
+  }
+}
+
+// 下线并且所有goroutine（登录、synccheck/sync和其中的回调）都退出后关闭
+func (bot *Bot) Done() <-chan struct{} {
+  return bot.done
+}
+
+// 等待下线并且所有goroutine都退出，
+// 不能在回调中调用（回调所在的goroutine返回之前不会返回，会一直等待）
+func (bot *Bot) Wait() {
+  <-bot.done
+}
+
+// 记录一个正在运行的goroutine，已下线时返回false
+func (bot *Bot) enter() bool {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  if bot.State() == StateStop {
+    return false
+  }
+  bot.running++
+  return true
+}
+
+func (bot *Bot) leave() {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.running--
+  bot.closeDone()
+}
+
+// 在新的goroutine中执行f，Wait会等待它返回
+func (bot *Bot) spawn(f func()) bool {
+  if !bot.enter() {
+    return false
+  }
+  go func() {
+    defer bot.leave()
+    f()
+  }()
+  return true
+}
+
+// 调用时必须持有mu
+func (bot *Bot) closeDone() {
+  if bot.running == 0 && !bot.doneClosed && bot.State() == StateStop {
+    bot.doneClosed = true
+    close(bot.done)
+  }
+}
+
+// 标记为已下线并取消所有请求，
+// err是下线的原因，只记录第一次调用时的原因
+func (bot *Bot) shutdown(err error) {
+  old, ok := bot.transition(StateStop)
+  if !ok {
+    return
+  }
+  bot.mu.Lock()
+  bot.err = err
+  bot.StopTime = bot.now()
+  if bot.pathsTimer != nil {
+    bot.pathsTimer.Stop()
+  }
+  bot.closeDone()
+  cancel := bot.cancel
+  bot.mu.Unlock()
+  cancel()
+  if bot.manager != nil {
+    bot.manager.Remove(bot)
+  }
+  bot.notifyState(old, StateStop)
 }
 
+// 登录失败，记录原因（Err返回*SignInError）并回调OnSignIn
+func (bot *Bot) signInFailed(stage string, e error) {
+  se := signInError(stage, e)
+  bot.shutdown(se)
+  bot.onSignIn(se)
+}
+
+// 下线的原因，主动调用Stop或还没有下线时为nil，
+// 其他情况如：登录失败（*SignInError）、ctx被取消（context.Canceled）、在手机上退出（ErrSignedOut）等
+func (bot *Bot) Err() error {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  return bot.err
+}
+
+// 释放资源，会先调用Stop并等待所有goroutine退出（Wait），
+// 不能在回调（包括OnSignOut）中调用，否则会一直等待，
+// 应该在Wait返回之后或者在新的goroutine中调用
 func (bot *Bot) Release() {
+  bot.Stop()
+  bot.Wait()
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
   bot.handler = nil
+  bot.store = nil
   bot.client = nil
   bot.session = nil
   bot.req = nil
@@ -270,42 +403,32 @@ func (bot *Bot) Release() {
   bot.attr = nil
 }
 
+// 更新存放目录（按天分目录），
+// 这里只记录路径，目录在第一次写入时才创建
 func (bot *Bot) updatePaths() {
-  if bot.session.Uin == 0 {
+  // 由定时器调用时在单独的goroutine中
+  n := bot.sessionCopy().Uin
+  if bot.State() == StateStop || n == 0 {
     return
   }
-  uin := strconv.FormatInt(bot.session.Uin, 10)
-  dir := path.Join(rootDir, uin, time2.ShanghaiStrf(time2.DateFormat))
-  e := os.MkdirAll(dir, os.ModePerm)
-  if e != nil {
+  now := bot.now()
+  uin := strconv.FormatInt(n, 10)
+  dir := path.Join(bot.rootDir, uin, now.Format(time2.DateFormat))
+  bot.attr.Store(attrImageDir, path.Join(dir, "image"))
+  bot.attr.Store(attrVoiceDir, path.Join(dir, "voice"))
+  bot.attr.Store(attrVideoDir, path.Join(dir, "video"))
+  bot.attr.Store(attrFileDir, path.Join(dir, "file"))
+  bot.attr.Store(attrAvatarPath, path.Join(bot.rootDir, uin, "avatar.jpg"))
+
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  if bot.State() == StateStop {
     return
   }
-
-  image := path.Join(dir, "image")
-  e = os.MkdirAll(image, os.ModePerm)
-  if e == nil {
-    bot.attr.Store(attrImageDir, image)
-  }
-
-  voice := path.Join(dir, "voice")
-  e = os.MkdirAll(voice, os.ModePerm)
-  if e == nil {
-    bot.attr.Store(attrVoiceDir, voice)
-  }
-
-  video := path.Join(dir, "video")
-  e = os.MkdirAll(video, os.ModePerm)
-  if e == nil {
-    bot.attr.Store(attrVideoDir, video)
+  if bot.pathsTimer != nil {
+    bot.pathsTimer.Stop()
   }
-
-  file := path.Join(dir, "file")
-  e = os.MkdirAll(file, os.ModePerm)
-  if e == nil {
-    bot.attr.Store(attrFileDir, file)
-  }
-
-  bot.attr.Store(attrAvatarPath, path.Join(rootDir, uin, "avatar.jpg"))
+  bot.pathsTimer = time.AfterFunc(time2.DurationUntilTomorrow(now), bot.updatePaths)
 }
 
 type session struct {
@@ -313,12 +436,18 @@ type session struct {
   SyncCheckHost string
   Referer       string
   BaseUrl       string
+  FileHost      string
 
-  State int
+  // 当前集群所有的synccheck Host（包括SyncCheckHost）
+  SyncCheckHosts []string
 
   UUID      string
   QRCodeUrl string
 
+  // 第一次获取二维码的时间和已刷新的次数
+  QRTime    time.Time
+  QRRefresh int
+
   RedirectUrl string
 
   SKey       string
@@ -327,18 +456,51 @@ type session struct {
   PassTicket string
   BaseReq    baseReq
 
-  SyncKey   syncKey
-  UserName  string
-  AvatarUrl string
+  // webwxsync使用SyncKey，synccheck使用SyncCheckKey，
+  // 两者都由webwxsync返回，不能混用
+  SyncKey      syncKey
+  SyncCheckKey syncKey
+  UserName     string
+  AvatarUrl    string
 
   WuFile int
+
+  // 使用SessionStore保存的凭据恢复登录
+  Resumed bool
+
+  // UUID是通过push登录获取的（在手机上确认，不需要扫码）
+  Pushed bool
+}
+
+// 返回session的副本，
+// 在synccheck/sync以外的goroutine（如回调中发消息）中读取session时使用，
+// 修改session时（登录以后）必须持有mu
+func (bot *Bot) sessionCopy() session {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  if bot.session == nil {
+    return session{}
+  }
+  return *bot.session
+}
+
+// 返回当前的WuFile并加1（上传文件时使用）
+func (bot *Bot) nextWuFile() int {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  ret := bot.session.WuFile
+  bot.session.WuFile++
+  return ret
+}
+
+// 清空凭据，重新扫码登录前调用
+func (s *session) reset() {
+  *s = session{}
+  s.init()
 }
 
 func (s *session) init() {
-  s.Host = "wx.qq.com"
-  s.SyncCheckHost = "webpush.weixin.qq.com"
-  s.Referer = "https://wx.qq.com/"
-  s.BaseUrl = "https://wx.qq.com/cgi-bin/mmwebwx-bin"
+  s.selectCluster("wx.qq.com")
 }
 
 type baseReq struct {
@@ -3,6 +3,7 @@ package wxweb
 import (
   "io/ioutil"
   "os"
+  "path"
   "strconv"
   "time"
 
@@ -39,6 +40,25 @@ func (bot *Bot) Verify(toUserName, ticket string) error {
   return nil
 }
 
+// 添加名片中的人为好友，greeting是验证信息（可以为空）
+func (bot *Bot) AddFriendFromCard(card *Card, greeting string) error {
+  if card == nil || card.UserName == "" {
+    return base.ErrInvalidArgument
+  }
+  resp, e := bot.req.AddFriend(card.UserName, card.Ticket, greeting)
+  if e != nil {
+    return e
+  }
+  code, e := jsonparser.GetInt(resp, "BaseResponse", "Ret")
+  if e != nil {
+    return e
+  }
+  if code != 0 {
+    return ErrResp
+  }
+  return nil
+}
+
 func (bot *Bot) Remark(toUserName, remark string) error {
   if toUserName == "" || remark == "" {
     return base.ErrInvalidArgument
@@ -120,10 +140,11 @@ func (bot *Bot) SendText(toUserName string, text string) error {
   if toUserName == "" || text == "" {
     return base.ErrInvalidArgument
   }
-  if bot.contacts == nil {
+  contacts := bot.Contacts()
+  if contacts == nil {
     return ErrInvalidState
   }
-  if c := bot.contacts.Get(toUserName); c != nil {
+  if c := contacts.Get(toUserName); c != nil {
     return bot.sendText(c.UserName, text)
   }
   return ErrContactNotFound
@@ -148,10 +169,11 @@ func (bot *Bot) SendImage(toUserName string, data []byte, filename string) (stri
   if toUserName == "" || len(data) == 0 || filename == "" {
     return "", base.ErrInvalidArgument
   }
-  if bot.contacts == nil {
+  contacts := bot.Contacts()
+  if contacts == nil {
     return "", ErrInvalidState
   }
-  if c := bot.contacts.Get(toUserName); c != nil {
+  if c := contacts.Get(toUserName); c != nil {
     return bot.sendMedia(c.UserName, data, filename, MsgImage, sendImageUrlPath)
   }
   return "", ErrContactNotFound
@@ -161,10 +183,11 @@ func (bot *Bot) SendVideo(toUserName string, data []byte, filename string) (stri
   if toUserName == "" || len(data) == 0 || filename == "" {
     return "", base.ErrInvalidArgument
   }
-  if bot.contacts == nil {
+  contacts := bot.Contacts()
+  if contacts == nil {
     return "", ErrInvalidState
   }
-  if c := bot.contacts.Get(toUserName); c != nil {
+  if c := contacts.Get(toUserName); c != nil {
     return bot.sendMedia(c.UserName, data, filename, MsgVideo, sendVideoUrlPath)
   }
   return "", ErrContactNotFound
@@ -196,10 +219,11 @@ func (bot *Bot) ForwardImage(toUserName, mediaId string) error {
   if toUserName == "" || mediaId == "" {
     return base.ErrInvalidArgument
   }
-  if bot.contacts == nil {
+  contacts := bot.Contacts()
+  if contacts == nil {
     return ErrInvalidState
   }
-  if c := bot.contacts.Get(toUserName); c != nil {
+  if c := contacts.Get(toUserName); c != nil {
     _, e := bot.req.SendMedia(c.UserName, mediaId, MsgImage, sendImageUrlPath)
     return e
   }
@@ -210,10 +234,11 @@ func (bot *Bot) ForwardVideo(toUserName, mediaId string) error {
   if toUserName == "" || mediaId == "" {
     return base.ErrInvalidArgument
   }
-  if bot.contacts == nil {
+  contacts := bot.Contacts()
+  if contacts == nil {
     return ErrInvalidState
   }
-  if c := bot.contacts.Get(toUserName); c != nil {
+  if c := contacts.Get(toUserName); c != nil {
     _, e := bot.req.SendMedia(c.UserName, mediaId, MsgVideo, sendVideoUrlPath)
     return e
   }
@@ -230,7 +255,9 @@ func (bot *Bot) Accept(toUserName, ticket string) (*Contact, error) {
   if e != nil {
     return nil, e
   }
-  bot.contacts.Add(c)
+  if contacts := bot.Contacts(); contacts != nil {
+    contacts.Add(c)
+  }
   return c, nil
 }
 
@@ -316,12 +343,24 @@ func timestampStringR(l int) string {
   return s
 }
 
-func sleep() {
-  time.Sleep(rand2.RandMilliseconds(1000, 3000))
+// 随机等待1~3秒，ctx被取消时立即返回false
+func (bot *Bot) sleep() bool {
+  t := time.NewTimer(rand2.RandMilliseconds(1000, 3000))
+  defer t.Stop()
+  select {
+  case <-bot.ctx.Done():
+    return false
+  case <-t.C:
+    return true
+  }
 }
 
-func dump(filename string, data []byte) {
+func (bot *Bot) dump(filename string, data []byte) {
   if dumpEnabled && filename != "" && len(data) > 0 {
-    ioutil.WriteFile(dumpDir+filename, data, os.ModePerm)
+    dir := path.Join(bot.rootDir, "dump")
+    if e := os.MkdirAll(dir, os.ModePerm); e != nil {
+      return
+    }
+    ioutil.WriteFile(path.Join(dir, filename), data, os.ModePerm)
   }
 }
@@ -0,0 +1,164 @@
+package wxweb
+
+import (
+  "errors"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+var errTestTransport = errors.New("test transport error")
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+  return f(req)
+}
+
+// 所有请求都返回errTestTransport
+func failingTransport() http.RoundTripper {
+  return roundTripFunc(func(*http.Request) (*http.Response, error) {
+    return nil, errTestTransport
+  })
+}
+
+// 按请求路径的最后一段返回body
+func stubTransport(bodies map[string]string) http.RoundTripper {
+  return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+    for k, v := range bodies {
+      if strings.HasSuffix(req.URL.Path, "/"+k) {
+        return &http.Response{
+          StatusCode: http.StatusOK,
+          Body:       ioutil.NopCloser(strings.NewReader(v)),
+          Header:     http.Header{},
+          Request:    req,
+        }, nil
+      }
+    }
+    return nil, errTestTransport
+  })
+}
+
+type testHandler struct {
+  mu           sync.Mutex
+  signIn       []error
+  signOut      int
+  reconnecting []int
+  messages     []*Message
+  contacts     []*ContactEvent
+  groups       []*GroupEvent
+  errs         []error
+  states       [][2]int
+}
+
+func (h *testHandler) OnSignIn(e error) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.signIn = append(h.signIn, e)
+}
+
+func (h *testHandler) OnSignOut() {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.signOut++
+}
+
+func (h *testHandler) OnQRCode(string) {}
+
+func (h *testHandler) OnContact(*Contact, int) {}
+
+func (h *testHandler) OnContactEvent(e *ContactEvent) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.contacts = append(h.contacts, e)
+}
+
+func (h *testHandler) OnGroupEvent(e *GroupEvent) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.groups = append(h.groups, e)
+}
+
+func (h *testHandler) OnMessage(msg *Message, _ int) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.messages = append(h.messages, msg)
+}
+
+func (h *testHandler) OnReconnecting(code int, _ int) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.reconnecting = append(h.reconnecting, code)
+}
+
+func (h *testHandler) OnReconnected() {}
+
+func (h *testHandler) OnError(e error) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.errs = append(h.errs, e)
+}
+
+func (h *testHandler) OnStateChange(old, state int) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.states = append(h.states, [2]int{old, state})
+}
+
+// 临时目录，测试结束后删除（go.mod是1.14，不能用t.TempDir）
+func tempDir(t *testing.T) string {
+  t.Helper()
+  dir, e := ioutil.TempDir("", "wxweb")
+  if e != nil {
+    t.Fatal(e)
+  }
+  t.Cleanup(func() { os.RemoveAll(dir) })
+  return dir
+}
+
+func newTestBot(t *testing.T, rt http.RoundTripper, opts ...Option) *Bot {
+  t.Helper()
+  arr := []Option{
+    WithTransport(rt),
+    WithRootDir(tempDir(t)),
+    WithManager(NewManager()),
+    WithBackoffPolicy(BackoffPolicy{Min: time.Millisecond, Max: time.Millisecond, MaxFailures: 3}),
+    WithWatchdogTimeout(0),
+    WithStopTimeout(time.Second),
+  }
+  bot := New(append(arr, opts...)...)
+  bot.session.Uin = 1
+  bot.session.UserName = "@self"
+  bot.contacts = initContacts(nil, bot)
+  bot.setSelf(&Contact{bot: bot, attr: &sync.Map{}, UserName: "@self", NickName: "self"})
+  return bot
+}
+
+// 跳过登录，直接开始synccheck/sync
+func startTestLoop(t *testing.T, bot *Bot, h Handler) {
+  t.Helper()
+  bot.handler = h
+  bot.started = true
+  if !bot.setState(StateRunning) {
+    t.Fatalf("state %d", bot.State())
+  }
+  bot.mu.Lock()
+  bot.health.lastSyncCheck = bot.now()
+  bot.mu.Unlock()
+  bot.seen = newSeenSet(bot.dedupPolicy)
+  bot.startDispatcher()
+  bot.spawn((&syncReq{bot}).loop)
+}
+
+func waitDone(t *testing.T, bot *Bot) {
+  t.Helper()
+  select {
+  case <-bot.Done():
+  case <-time.After(time.Second * 5):
+    t.Fatal("bot not done")
+  }
+}
@@ -0,0 +1,75 @@
+package wxweb
+
+import (
+  "errors"
+
+  "github.com/buger/jsonparser"
+)
+
+var ErrNotCard = errors.New("not a card message")
+
+// 名片消息（MsgCard）中分享的人（消息中的RecommendInfo）
+type Card struct {
+  // 被分享的人的UserName，添加好友时使用
+  UserName string
+  NickName string
+  Alias    string
+
+  // 1为男，2为女，0为未知
+  Sex int
+
+  Province string
+  City     string
+
+  Ticket string
+
+  // 原始的RecommendInfo
+  Raw []byte
+}
+
+// 解析名片消息，其他类型的消息返回ErrNotCard
+func (msg *Message) Card() (*Card, error) {
+  if msg.Type != MsgCard {
+    return nil, ErrNotCard
+  }
+  data, _, _, e := jsonparser.Get(msg.raw, "RecommendInfo")
+  if e != nil {
+    return nil, ErrNotCard
+  }
+  ret := &Card{Raw: data}
+  jsonparser.EachKey(data, func(i int, v []byte, _ jsonparser.ValueType, e error) {
+    if e != nil {
+      return
+    }
+    switch i {
+    case 0:
+      ret.UserName, _ = jsonparser.ParseString(v)
+    case 1:
+      ret.NickName, _ = jsonparser.ParseString(v)
+    case 2:
+      ret.Alias, _ = jsonparser.ParseString(v)
+    case 3:
+      n, _ := jsonparser.ParseInt(v)
+      ret.Sex = int(n)
+    case 4:
+      ret.Province, _ = jsonparser.ParseString(v)
+    case 5:
+      ret.City, _ = jsonparser.ParseString(v)
+    case 6:
+      ret.Ticket, _ = jsonparser.ParseString(v)
+    }
+  }, []string{"UserName"}, []string{"NickName"}, []string{"Alias"}, []string{"Sex"}, []string{"Province"}, []string{"City"}, []string{"Ticket"})
+  if ret.UserName == "" {
+    return nil, ErrNotCard
+  }
+  return ret, nil
+}
+
+// 添加名片中的人为好友
+func (msg *Message) AddFriendFromCard(greeting string) error {
+  card, e := msg.Card()
+  if e != nil {
+    return e
+  }
+  return msg.bot.AddFriendFromCard(card, greeting)
+}
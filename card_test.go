@@ -0,0 +1,83 @@
+package wxweb
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "reflect"
+  "testing"
+)
+
+func TestMessageCard(t *testing.T) {
+  tests := []struct {
+    name    string
+    typ     int
+    raw     string
+    want    *Card
+    wantErr error
+  }{
+    {"not card", MsgText, `{"RecommendInfo":{"UserName":"@a"}}`, nil, ErrNotCard},
+    {"no recommend info", MsgCard, `{}`, nil, ErrNotCard},
+    {"no user name", MsgCard, `{"RecommendInfo":{"NickName":"a"}}`, nil, ErrNotCard},
+    {"card", MsgCard, `{"RecommendInfo":{"UserName":"@a","NickName":"a","Alias":"al","Sex":2,"Province":"p","City":"c","Ticket":"t"}}`,
+      &Card{UserName: "@a", NickName: "a", Alias: "al", Sex: 2, Province: "p", City: "c", Ticket: "t"}, nil},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      msg := &Message{Type: tt.typ, raw: []byte(tt.raw)}
+      got, e := msg.Card()
+      if e != tt.wantErr {
+        t.Fatalf("Card() error = %v, want %v", e, tt.wantErr)
+      }
+      if tt.want == nil {
+        return
+      }
+      if len(got.Raw) == 0 {
+        t.Error("Raw is empty")
+      }
+      got.Raw = nil
+      if !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("Card() = %+v, want %+v", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestMessageAddFriendFromCard(t *testing.T) {
+  tests := []struct {
+    name    string
+    resp    string
+    wantErr error
+  }{
+    {"ok", `{"BaseResponse":{"Ret":0}}`, nil},
+    {"rejected", `{"BaseResponse":{"Ret":1}}`, ErrResp},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      var body map[string]interface{}
+      rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+        buf, _ := ioutil.ReadAll(req.Body)
+        json.Unmarshal(buf, &body)
+        return stubTransport(map[string]string{"webwxverifyuser": tt.resp}).RoundTrip(req)
+      })
+      bot := newTestBot(t, rt)
+      msg := buildMessage([]byte(`{"MsgId":"1","MsgType":42,"FromUserName":"@b","RecommendInfo":{"UserName":"@a","Ticket":"t"}}`), bot)
+      if e := msg.AddFriendFromCard("hi"); e != tt.wantErr {
+        t.Fatalf("AddFriendFromCard() error = %v, want %v", e, tt.wantErr)
+      }
+      list, _ := body["VerifyUserList"].([]interface{})
+      if body["Opcode"] != float64(2) || body["VerifyContent"] != "hi" || len(list) != 1 {
+        t.Fatalf("request = %v", body)
+      }
+      u := list[0].(map[string]interface{})
+      if u["Value"] != "@a" || u["VerifyUserTicket"] != "t" {
+        t.Errorf("VerifyUserList = %v", list)
+      }
+    })
+  }
+  bot := newTestBot(t, failingTransport())
+  msg := &Message{bot: bot, Type: MsgText, raw: []byte(`{}`)}
+  if e := msg.AddFriendFromCard("hi"); e != ErrNotCard {
+    t.Errorf("AddFriendFromCard() on text message = %v, want ErrNotCard", e)
+  }
+}
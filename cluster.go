@@ -0,0 +1,109 @@
+package wxweb
+
+import (
+  "strings"
+)
+
+// Web微信的集群，
+// 登录后的Host由redirect_uri决定（可能是根据帐号注册时间或地区分配的），
+// 之后所有请求（包括上传文件和synccheck）都必须使用该集群对应的Host，否则会返回1100错误码
+type cluster struct {
+  // 匹配redirect_uri的Host（包含即可，按顺序匹配，与Web微信的判断方式相同）
+  match string
+
+  // 上传/下载文件的Host
+  fileHost string
+
+  // synccheck的Host，第一个是默认的，
+  // 其他的是synccheck一直失败时依次尝试的备用Host
+  syncCheckHosts []string
+}
+
+var clusters = []cluster{
+  {
+    match:          "wx2.qq.com",
+    fileHost:       "file.wx2.qq.com",
+    syncCheckHosts: []string{"webpush.wx2.qq.com", "webpush1.wx2.qq.com", "webpush2.wx2.qq.com"},
+  },
+  {
+    match:          "wx8.qq.com",
+    fileHost:       "file.wx8.qq.com",
+    syncCheckHosts: []string{"webpush.wx8.qq.com", "webpush1.wx8.qq.com", "webpush2.wx8.qq.com"},
+  },
+  {
+    match:          "qq.com",
+    fileHost:       "file.wx.qq.com",
+    syncCheckHosts: []string{"webpush.weixin.qq.com", "webpush.wx.qq.com", "webpush1.wx.qq.com", "webpush2.wx.qq.com"},
+  },
+  {
+    match:          "web2.wechat.com",
+    fileHost:       "file.web2.wechat.com",
+    syncCheckHosts: []string{"webpush.web2.wechat.com", "webpush1.web2.wechat.com", "webpush2.web2.wechat.com"},
+  },
+  {
+    // 海外帐号（web.wechat.com或wechat.com）
+    match:          "wechat.com",
+    fileHost:       "file.web.wechat.com",
+    syncCheckHosts: []string{"webpush.web.wechat.com", "webpush1.web.wechat.com", "webpush2.web.wechat.com"},
+  },
+}
+
+func findCluster(host string) *cluster {
+  for i := range clusters {
+    if strings.Contains(host, clusters[i].match) {
+      return &clusters[i]
+    }
+  }
+  return nil
+}
+
+// 根据Host设置集群相关的地址，
+// 不在集群表中的Host使用wx.qq.com的设置
+func (s *session) selectCluster(host string) {
+  if host == "" {
+    host = "wx.qq.com"
+  }
+  // 海外帐号的redirect_uri可能直接是wechat.com
+  if host == "wechat.com" {
+    host = "web.wechat.com"
+  }
+  c := findCluster(host)
+  if c == nil {
+    c = findCluster("wx.qq.com")
+  }
+  s.Host = host
+  s.Referer = "https://" + host + "/"
+  s.BaseUrl = "https://" + host + "/cgi-bin/mmwebwx-bin"
+  s.FileHost = c.fileHost
+  s.SyncCheckHosts = c.syncCheckHosts
+  s.SyncCheckHost = c.syncCheckHosts[0]
+}
+
+// 同session.nextSyncCheckHost，其他goroutine可能正在读取session，需要持有mu
+func (bot *Bot) nextSyncCheckHost() bool {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  return bot.session.nextSyncCheckHost()
+}
+
+// 切换到下一个备用的synccheck Host，
+// 返回false表示已经没有其他Host可以尝试了（又回到了第一个）
+func (s *session) nextSyncCheckHost() bool {
+  if len(s.SyncCheckHosts) == 0 {
+    return false
+  }
+  i := -1
+  for j, v := range s.SyncCheckHosts {
+    if v == s.SyncCheckHost {
+      i = j
+      break
+    }
+  }
+  i++
+  if i >= len(s.SyncCheckHosts) {
+    s.SyncCheckHost = s.SyncCheckHosts[0]
+    return false
+  }
+  s.SyncCheckHost = s.SyncCheckHosts[i]
+  return true
+}
@@ -0,0 +1,55 @@
+package wxweb
+
+import (
+  "testing"
+)
+
+func TestSelectCluster(t *testing.T) {
+  tests := []struct {
+    host          string
+    wantHost      string
+    wantFileHost  string
+    wantSyncCheck string
+  }{
+    {"", "wx.qq.com", "file.wx.qq.com", "webpush.weixin.qq.com"},
+    {"wx.qq.com", "wx.qq.com", "file.wx.qq.com", "webpush.weixin.qq.com"},
+    {"wx2.qq.com", "wx2.qq.com", "file.wx2.qq.com", "webpush.wx2.qq.com"},
+    {"wx8.qq.com", "wx8.qq.com", "file.wx8.qq.com", "webpush.wx8.qq.com"},
+    {"web.wechat.com", "web.wechat.com", "file.web.wechat.com", "webpush.web.wechat.com"},
+    {"wechat.com", "web.wechat.com", "file.web.wechat.com", "webpush.web.wechat.com"},
+    {"web2.wechat.com", "web2.wechat.com", "file.web2.wechat.com", "webpush.web2.wechat.com"},
+    {"example.com", "example.com", "file.wx.qq.com", "webpush.weixin.qq.com"},
+  }
+  for _, tt := range tests {
+    s := &session{}
+    s.selectCluster(tt.host)
+    if s.Host != tt.wantHost || s.FileHost != tt.wantFileHost || s.SyncCheckHost != tt.wantSyncCheck {
+      t.Errorf("selectCluster(%q) = %s, %s, %s", tt.host, s.Host, s.FileHost, s.SyncCheckHost)
+    }
+    if s.BaseUrl != "https://"+tt.wantHost+"/cgi-bin/mmwebwx-bin" || s.Referer != "https://"+tt.wantHost+"/" {
+      t.Errorf("selectCluster(%q) BaseUrl = %s, Referer = %s", tt.host, s.BaseUrl, s.Referer)
+    }
+  }
+}
+
+func TestNextSyncCheckHost(t *testing.T) {
+  s := &session{}
+  s.selectCluster("wx2.qq.com")
+  tests := []struct {
+    want     string
+    wantNext bool
+  }{
+    {"webpush1.wx2.qq.com", true},
+    {"webpush2.wx2.qq.com", true},
+    {"webpush.wx2.qq.com", false},
+    {"webpush1.wx2.qq.com", true},
+  }
+  for i, tt := range tests {
+    if ok := s.nextSyncCheckHost(); ok != tt.wantNext || s.SyncCheckHost != tt.want {
+      t.Errorf("step %d: nextSyncCheckHost() = %v, %s, want %v, %s", i, ok, s.SyncCheckHost, tt.wantNext, tt.want)
+    }
+  }
+  if (&session{}).nextSyncCheckHost() {
+    t.Error("nextSyncCheckHost() without hosts = true")
+  }
+}
@@ -25,11 +25,12 @@ const (
 )
 
 var (
-  jsonPathUserName    = []string{"UserName"}
-  jsonPathNickName    = []string{"NickName"}
-  jsonPathRemarkName  = []string{"RemarkName"}
-  jsonPathVerifyFlag  = []string{"VerifyFlag"}
-  jsonPathMemberCount = []string{"MemberCount"}
+  jsonPathUserName      = []string{"UserName"}
+  jsonPathNickName      = []string{"NickName"}
+  jsonPathRemarkName    = []string{"RemarkName"}
+  jsonPathVerifyFlag    = []string{"VerifyFlag"}
+  jsonPathMemberCount   = []string{"MemberCount"}
+  jsonPathChatRoomOwner = []string{"ChatRoomOwner"}
 
   jsonKeyMemberList = "MemberList"
   jsonKeyUserName   = "UserName"
@@ -66,6 +67,9 @@ type Contact struct {
   // 只在调用Update后才有值，UserName->NickName
   Members map[string]string
 
+  // 群主的UserName（仅群有该字段）
+  ChatRoomOwner string
+
   // 原始数据
   raw []byte
 }
@@ -96,8 +100,10 @@ func buildContact(data []byte, bot *Bot) *Contact {
       if cnt > 0 {
         ret.Members = make(map[string]string, cnt)
       }
+    case 5:
+      ret.ChatRoomOwner, _ = jsonparser.ParseString(v)
     }
-  }, jsonPathUserName, jsonPathNickName, jsonPathRemarkName, jsonPathVerifyFlag, jsonPathMemberCount)
+  }, jsonPathUserName, jsonPathNickName, jsonPathRemarkName, jsonPathVerifyFlag, jsonPathMemberCount, jsonPathChatRoomOwner)
   if ret.Members != nil {
     v, _, _, _ := jsonparser.Get(data, jsonKeyMemberList)
     if len(v) > 0 {
@@ -130,23 +136,6 @@ func buildMembers(data []byte, m map[string]string) {
   })
 }
 
-func GetContact(userName string) *Contact {
-  if userName == "" {
-    return nil
-  }
-  var ret *Contact
-  EachBot(func(b *Bot) bool {
-    if b.contacts != nil {
-      if c := b.contacts.Get(userName); c != nil {
-        ret = c
-        return false
-      }
-    }
-    return true
-  })
-  return ret
-}
-
 func (c *Contact) Bot() *Bot {
   return c.bot
 }
@@ -160,7 +149,9 @@ func (c *Contact) Update() *Contact {
   if ret == nil {
     return nil
   }
-  ret.bot.contacts.Add(ret)
+  if contacts := ret.bot.Contacts(); contacts != nil {
+    contacts.Add(ret)
+  }
   return ret
 }
 
@@ -0,0 +1,97 @@
+package wxweb
+
+// 联系人变化的类型（OnContact的第二个参数）
+const (
+  // 新增（加好友、建群、被拉入群等）
+  ContactAdded = iota + 1
+
+  // 资料修改（改昵称、备注、群改名、群成员变更等）
+  ContactModified
+
+  // 删除（删除好友、退群或被移出群等）
+  ContactDeleted
+)
+
+// 联系人变化事件（可选），
+// Handler实现了该接口时回调OnContactEvent，不再回调OnContact
+type ContactEventHandler interface {
+  OnContactEvent(*ContactEvent)
+}
+
+type ContactEvent struct {
+  Kind int
+
+  // 变化前的联系人，Added时为nil，
+  // Deleted时如果本地没有该联系人，则是服务器返回的数据
+  Old *Contact
+
+  // 变化后的联系人，Deleted时为nil
+  New *Contact
+
+  // 变化的字段（仅Modified），如：NickName、RemarkName、VerifyFlag、Members、ChatRoomOwner，
+  // 服务器返回的其他字段（如头像）变化时可能为空
+  ChangedFields []string
+}
+
+// 变化的联系人（Deleted时是Old，其他是New）
+func (e *ContactEvent) Contact() *Contact {
+  if e.Kind == ContactDeleted {
+    return e.Old
+  }
+  return e.New
+}
+
+// 更新联系人并返回变化事件
+func (cs *Contacts) apply(c *Contact, deleted bool) *ContactEvent {
+  cs.mu.Lock()
+  defer cs.mu.Unlock()
+  old := cs.data[c.UserName]
+  if deleted {
+    delete(cs.data, c.UserName)
+    if old == nil {
+      old = c
+    }
+    return &ContactEvent{Kind: ContactDeleted, Old: old}
+  }
+  // 更新中没有成员列表时保留之前的，否则会被当成所有成员都退出了
+  if old != nil && len(c.Members) == 0 && len(old.Members) > 0 {
+    c.Members = old.Members
+  }
+  cs.data[c.UserName] = c
+  if old == nil {
+    return &ContactEvent{Kind: ContactAdded, New: c}
+  }
+  return &ContactEvent{Kind: ContactModified, Old: old, New: c, ChangedFields: diffContact(old, c)}
+}
+
+func diffContact(old, c *Contact) []string {
+  var ret []string
+  if old.NickName != c.NickName {
+    ret = append(ret, "NickName")
+  }
+  if old.RemarkName != c.RemarkName {
+    ret = append(ret, "RemarkName")
+  }
+  if old.VerifyFlag != c.VerifyFlag {
+    ret = append(ret, "VerifyFlag")
+  }
+  if !equalMembers(old.Members, c.Members) {
+    ret = append(ret, "Members")
+  }
+  if old.ChatRoomOwner != c.ChatRoomOwner {
+    ret = append(ret, "ChatRoomOwner")
+  }
+  return ret
+}
+
+func equalMembers(a, b map[string]string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for k, v := range a {
+    if v2, ok := b[k]; !ok || v2 != v {
+      return false
+    }
+  }
+  return true
+}
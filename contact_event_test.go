@@ -0,0 +1,74 @@
+package wxweb
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestDiffContact(t *testing.T) {
+  tests := []struct {
+    name string
+    old  *Contact
+    new  *Contact
+    want []string
+  }{
+    {"same", &Contact{NickName: "a"}, &Contact{NickName: "a"}, nil},
+    {"nickname", &Contact{NickName: "a"}, &Contact{NickName: "b"}, []string{"NickName"}},
+    {"remark and verify", &Contact{RemarkName: "a"}, &Contact{RemarkName: "b", VerifyFlag: 8}, []string{"RemarkName", "VerifyFlag"}},
+    {"members", &Contact{Members: map[string]string{"@a": "a"}}, &Contact{Members: map[string]string{"@b": "b"}}, []string{"Members"}},
+    {"owner", &Contact{ChatRoomOwner: "@a"}, &Contact{ChatRoomOwner: "@b"}, []string{"ChatRoomOwner"}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if got := diffContact(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("diffContact() = %v, want %v", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestContactsApply(t *testing.T) {
+  members := map[string]string{"@a": "a", "@b": "b"}
+  tests := []struct {
+    name        string
+    old         *Contact
+    new         *Contact
+    deleted     bool
+    wantKind    int
+    wantFields  []string
+    wantMembers int
+  }{
+    {"added", nil, &Contact{UserName: "@@g"}, false, ContactAdded, nil, 0},
+    {"modified", &Contact{UserName: "@@g", NickName: "a"}, &Contact{UserName: "@@g", NickName: "b"}, false, ContactModified, []string{"NickName"}, 0},
+    {"keep members", &Contact{UserName: "@@g", Members: members}, &Contact{UserName: "@@g", Members: map[string]string{}}, false, ContactModified, nil, 2},
+    {"deleted", &Contact{UserName: "@@g"}, &Contact{UserName: "@@g"}, true, ContactDeleted, nil, 0},
+    {"deleted unknown", nil, &Contact{UserName: "@@g"}, true, ContactDeleted, nil, 0},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      cs := initContacts(nil, nil)
+      if tt.old != nil {
+        cs.Add(tt.old)
+      }
+      e := cs.apply(tt.new, tt.deleted)
+      if e.Kind != tt.wantKind {
+        t.Fatalf("Kind = %d, want %d", e.Kind, tt.wantKind)
+      }
+      if !reflect.DeepEqual(e.ChangedFields, tt.wantFields) {
+        t.Errorf("ChangedFields = %v, want %v", e.ChangedFields, tt.wantFields)
+      }
+      if e.Contact() == nil {
+        t.Fatal("Contact() = nil")
+      }
+      if tt.deleted {
+        if cs.Get(tt.new.UserName) != nil {
+          t.Error("contact not removed")
+        }
+        return
+      }
+      if n := len(cs.Get(tt.new.UserName).Members); n != tt.wantMembers {
+        t.Errorf("len(Members) = %d, want %d", n, tt.wantMembers)
+      }
+    })
+  }
+}
@@ -0,0 +1,194 @@
+package wxweb
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+)
+
+// 消息去重策略，
+// webwxsync重试或SyncKey回退时可能会重复返回同一条消息，
+// 在Window时间内收到Id相同的消息只回调一次
+type DedupPolicy struct {
+  // 最多记录多少条消息，超过后丢弃最早的，0表示不去重，默认4096
+  Size int
+
+  // 去重的时间范围，0表示只受Size限制，默认1小时
+  Window time.Duration
+
+  // 保存已收到消息的文件，每收到seenSaveCount条新消息和下线时保存，
+  // 登录时加载（重启后也能去重，进程异常退出时最多丢失seenSaveCount条），空表示不保存
+  Path string
+}
+
+// 每收到多少条新消息保存一次
+const seenSaveCount = 64
+
+var defaultDedupPolicy = DedupPolicy{
+  Size:   4096,
+  Window: time.Hour,
+}
+
+func (bot *Bot) SetDedupPolicy(policy DedupPolicy) {
+  if policy.Size < 0 {
+    policy.Size = 0
+  }
+  if policy.Window < 0 {
+    policy.Window = 0
+  }
+  bot.dedupPolicy = policy
+}
+
+type seenItem struct {
+  Id   string    `json:"id"`
+  Time time.Time `json:"time"`
+}
+
+// 已收到的消息（按收到的顺序）
+type seenSet struct {
+  mu     sync.Mutex
+  policy DedupPolicy
+  items  []seenItem
+  ids    map[string]time.Time
+
+  // 上次保存之后新记录的消息数
+  unsaved int
+}
+
+func newSeenSet(policy DedupPolicy) *seenSet {
+  return &seenSet{policy: policy, ids: make(map[string]time.Time, policy.Size)}
+}
+
+// 返回true表示已经收到过，否则记录下来
+func (s *seenSet) seen(id string, now time.Time) bool {
+  if s.policy.Size <= 0 || id == "" {
+    return false
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.expire(now)
+  if _, ok := s.ids[id]; ok {
+    return true
+  }
+  s.items = append(s.items, seenItem{id, now})
+  s.ids[id] = now
+  s.unsaved++
+  for len(s.items) > s.policy.Size {
+    s.evict()
+  }
+  return false
+}
+
+func (s *seenSet) expire(now time.Time) {
+  if s.policy.Window <= 0 {
+    return
+  }
+  for len(s.items) > 0 && now.Sub(s.items[0].Time) >= s.policy.Window {
+    s.evict()
+  }
+}
+
+func (s *seenSet) evict() {
+  item := s.items[0]
+  s.items[0] = seenItem{}
+  s.items = s.items[1:]
+  // 加载的文件中可能有重复的Id，只有最后一次记录被移除时才删除
+  if t, ok := s.ids[item.Id]; ok && !t.After(item.Time) {
+    delete(s.ids, item.Id)
+  }
+}
+
+func (s *seenSet) load(now time.Time) error {
+  if s.policy.Path == "" {
+    return nil
+  }
+  data, e := ioutil.ReadFile(s.policy.Path)
+  if e != nil {
+    if os.IsNotExist(e) {
+      return nil
+    }
+    return e
+  }
+  var arr []seenItem
+  if e = json.Unmarshal(data, &arr); e != nil {
+    return e
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for _, v := range arr {
+    if v.Id == "" {
+      continue
+    }
+    s.items = append(s.items, v)
+    s.ids[v.Id] = v.Time
+  }
+  for len(s.items) > s.policy.Size {
+    s.evict()
+  }
+  s.expire(now)
+  return nil
+}
+
+func (s *seenSet) save() error {
+  if s.policy.Path == "" {
+    return nil
+  }
+  s.mu.Lock()
+  data, e := json.Marshal(s.items)
+  n := s.unsaved
+  s.unsaved = 0
+  s.mu.Unlock()
+  if e != nil {
+    return e
+  }
+  e = os.MkdirAll(filepath.Dir(s.policy.Path), os.ModePerm)
+  if e == nil {
+    // 先写临时文件再重命名，与FileSessionStore相同
+    tmp := s.policy.Path + ".tmp"
+    if e = ioutil.WriteFile(tmp, data, 0600); e == nil {
+      e = os.Rename(tmp, s.policy.Path)
+    }
+  }
+  if e != nil {
+    // 保存失败，下次继续尝试
+    s.mu.Lock()
+    s.unsaved += n
+    s.mu.Unlock()
+  }
+  return e
+}
+
+// 新记录的消息数是否达到seenSaveCount
+func (s *seenSet) needSave() bool {
+  if s.policy.Path == "" {
+    return false
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.unsaved >= seenSaveCount
+}
+
+// 过滤掉已经收到过的消息
+func (bot *Bot) dedup(msgs []*Message) []*Message {
+  if bot.seen == nil {
+    return msgs
+  }
+  now := bot.now()
+  ret := msgs[:0]
+  for _, m := range msgs {
+    if bot.seen.seen(m.Id, now) {
+      bot.logger.Printf("wxweb: duplicate message %s", m.Id)
+      continue
+    }
+    ret = append(ret, m)
+  }
+  if bot.seen.needSave() {
+    if e := bot.seen.save(); e != nil {
+      bot.logger.Printf("wxweb: save seen messages failed: %v", e)
+    }
+  }
+  return ret
+}
@@ -0,0 +1,98 @@
+package wxweb
+
+import (
+  "path"
+  "strconv"
+  "testing"
+  "time"
+)
+
+func TestSeenSet(t *testing.T) {
+  t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+  type step struct {
+    id   string
+    at   time.Duration
+    want bool
+  }
+  tests := []struct {
+    name   string
+    policy DedupPolicy
+    steps  []step
+  }{
+    {"disabled", DedupPolicy{}, []step{{"a", 0, false}, {"a", 0, false}}},
+    {"empty id", DedupPolicy{Size: 4}, []step{{"", 0, false}, {"", 0, false}}},
+    {"duplicate", DedupPolicy{Size: 4}, []step{{"a", 0, false}, {"b", 0, false}, {"a", 0, true}}},
+    {"evict by size", DedupPolicy{Size: 2}, []step{{"a", 0, false}, {"b", 0, false}, {"c", 0, false}, {"a", 0, false}, {"c", 0, true}}},
+    {"expire by window", DedupPolicy{Size: 4, Window: time.Minute}, []step{{"a", 0, false}, {"a", time.Second * 30, true}, {"a", time.Minute * 2, false}}},
+    {"no window", DedupPolicy{Size: 4}, []step{{"a", 0, false}, {"a", time.Hour * 24, true}}},
+  }
+  for _, tt := range tests {
+    s := newSeenSet(tt.policy)
+    for i, v := range tt.steps {
+      if got := s.seen(v.id, t0.Add(v.at)); got != v.want {
+        t.Errorf("%s: step %d seen(%q) = %v, want %v", tt.name, i, v.id, got, v.want)
+      }
+    }
+  }
+}
+
+func TestSeenSetSaveLoad(t *testing.T) {
+  now := time.Now()
+  p := DedupPolicy{Size: 3, Window: time.Hour, Path: path.Join(tempDir(t), "seen.json")}
+  s := newSeenSet(p)
+  for _, id := range []string{"a", "b", "c", "d"} {
+    s.seen(id, now)
+  }
+  if e := s.save(); e != nil {
+    t.Fatal(e)
+  }
+  tests := []struct {
+    name string
+    at   time.Time
+    id   string
+    want bool
+  }{
+    {"kept", now, "d", true},
+    {"evicted before save", now, "a", false},
+    {"expired after load", now.Add(time.Hour * 2), "b", false},
+  }
+  for _, tt := range tests {
+    s2 := newSeenSet(p)
+    if e := s2.load(tt.at); e != nil {
+      t.Fatal(e)
+    }
+    if got := s2.seen(tt.id, tt.at); got != tt.want {
+      t.Errorf("%s: seen(%q) = %v, want %v", tt.name, tt.id, got, tt.want)
+    }
+  }
+}
+
+// 收到seenSaveCount条新消息后保存，不用等到下线
+func TestDedupSavesPeriodically(t *testing.T) {
+  bot := newTestBot(t, failingTransport(), WithDedupPolicy(DedupPolicy{Size: 1024, Path: path.Join(tempDir(t), "seen.json")}))
+  bot.seen = newSeenSet(bot.dedupPolicy)
+  tests := []struct {
+    name  string
+    count int
+    want  bool
+  }{
+    {"below", seenSaveCount - 1, false},
+    {"reached", 1, true},
+  }
+  n := 0
+  for _, tt := range tests {
+    var msgs []*Message
+    for i := 0; i < tt.count; i++ {
+      n++
+      msgs = append(msgs, &Message{Id: strconv.Itoa(n)})
+    }
+    bot.dedup(msgs)
+    s := newSeenSet(bot.dedupPolicy)
+    if e := s.load(bot.now()); e != nil {
+      t.Fatal(e)
+    }
+    if got := len(s.items) == n; got != tt.want {
+      t.Errorf("%s: saved %d items after %d messages", tt.name, len(s.items), n)
+    }
+  }
+}
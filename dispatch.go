@@ -1,51 +1,153 @@
 package wxweb
 
 import (
+  "fmt"
+  "sync"
+
   "github.com/buger/jsonparser"
 )
 
 var (
-  jsonPathModContactList = []string{"ModContactList"}
-  jsonPathDelContactList = []string{"DelContactList"}
-  jsonPathAddMsgList     = []string{"AddMsgList"}
-  jsonPathSyncCheckKey   = []string{"SyncCheckKey"}
+  jsonPathModContactList        = []string{"ModContactList"}
+  jsonPathDelContactList        = []string{"DelContactList"}
+  jsonPathModChatRoomMemberList = []string{"ModChatRoomMemberList"}
+  jsonPathAddMsgList            = []string{"AddMsgList"}
+  jsonPathSyncKey               = []string{"SyncKey"}
+  jsonPathSyncCheckKey          = []string{"SyncCheckKey"}
+  jsonPathProfile               = []string{"Profile"}
+  jsonPathContinueFlag          = []string{"ContinueFlag"}
 )
 
-func (bot *Bot) dispatch(syncCheck syncCheckResp, data []byte) {
+// 处理webwxsync的返回，返回ContinueFlag（不为0表示还有数据，需要再次webwxsync）
+func (bot *Bot) dispatch(data []byte) int {
   var modContactList, delContactList []*Contact
   var addMsgList []*Message
+  var profile *ContactEvent
+  continueFlag := 0
   jsonparser.EachKey(data, func(i int, v []byte, _ jsonparser.ValueType, e error) {
     if e != nil {
       return
     }
     switch i {
     case 0:
-      modContactList = bot.parseSyncContactList(v)
+      modContactList = append(modContactList, bot.parseSyncContactList(v)...)
     case 1:
       delContactList = bot.parseSyncContactList(v)
     case 2:
-      addMsgList = bot.parseSyncMsgList(v)
+      // 群成员变化，其中的群与ModContactList中的群一样处理，
+      // 其他的（群成员本身）不是联系人，不能当作联系人更新
+      for _, c := range bot.parseSyncContactList(v) {
+        if c.Type == ContactGroup {
+          modContactList = append(modContactList, c)
+        }
+      }
     case 3:
+      addMsgList = bot.parseSyncMsgList(v)
+    case 4:
       sk := parseSyncKey(v)
       if sk.Count > 0 {
+        bot.mu.Lock()
         bot.session.SyncKey = sk
+        bot.mu.Unlock()
       }
+    case 5:
+      sk := parseSyncKey(v)
+      if sk.Count > 0 {
+        bot.mu.Lock()
+        bot.session.SyncCheckKey = sk
+        bot.mu.Unlock()
+      }
+    case 6:
+      profile = bot.applyProfile(v)
+    case 7:
+      n, _ := jsonparser.ParseInt(v)
+      continueFlag = int(n)
     }
-  }, jsonPathModContactList, jsonPathDelContactList, jsonPathAddMsgList, jsonPathSyncCheckKey)
+  }, jsonPathModContactList, jsonPathDelContactList, jsonPathModChatRoomMemberList, jsonPathAddMsgList,
+    jsonPathSyncKey, jsonPathSyncCheckKey, jsonPathProfile, jsonPathContinueFlag)
+  bot.markSync(len(addMsgList))
+  if profile != nil {
+    bot.dispatcher.post(&event{kind: eventContact, contact: profile})
+  }
+  addMsgList = bot.dedup(addMsgList)
+  // 联系人在这里更新（保证顺序），回调在dispatcher中执行
+  contacts := bot.Contacts()
   for _, c := range modContactList {
-    bot.handler.OnContact(c, 0)
+    ce := contacts.apply(c, false)
+    bot.dispatcher.post(&event{kind: eventContact, contact: ce})
+    for _, ge := range groupEvents(ce) {
+      bot.dispatcher.post(&event{kind: eventGroup, group: ge})
+    }
   }
   for _, c := range delContactList {
-    bot.handler.OnContact(c, 0)
+    bot.dispatcher.post(&event{kind: eventContact, contact: contacts.apply(c, true)})
   }
   for _, m := range addMsgList {
-    if ok := bot.processVerifyMsg(m); ok {
-      continue
+    if ge := bot.parseGroupSystemMsg(m); ge != nil {
+      bot.dispatcher.post(&event{kind: eventGroup, group: ge})
+    }
+    bot.dispatcher.post(&event{kind: eventMessage, msg: m})
+  }
+  return continueFlag
+}
+
+// 自己的资料修改（如昵称、头像），BitFlag为0表示没有修改，
+// 更新Self并返回变化事件
+func (bot *Bot) applyProfile(data []byte) *ContactEvent {
+  flag, _ := jsonparser.GetInt(data, "BitFlag")
+  old := bot.Self()
+  if flag == 0 || old == nil {
+    return nil
+  }
+  c := *old
+  c.attr = &sync.Map{}
+  old.attr.Range(func(k, v interface{}) bool {
+    c.attr.Store(k, v)
+    return true
+  })
+  var fields []string
+  if nickName, _ := jsonparser.GetString(data, "NickName", "Buff"); nickName != "" && nickName != c.NickName {
+    c.NickName = nickName
+    fields = append(fields, "NickName")
+  }
+  headImgFlag, _ := jsonparser.GetInt(data, "HeadImgUpdateFlag")
+  headImgUrl, _ := jsonparser.GetString(data, "HeadImgUrl")
+  if headImgFlag != 0 && headImgUrl != "" {
+    c.attr.Store("HeadImgUrl", headImgUrl)
+    bot.mu.Lock()
+    bot.session.AvatarUrl = fmt.Sprintf("https://%s%s", bot.session.Host, headImgUrl)
+    bot.mu.Unlock()
+    fields = append(fields, "HeadImgUrl")
+  }
+  if len(fields) == 0 {
+    return nil
+  }
+  bot.setSelf(&c)
+  return &ContactEvent{Kind: ContactModified, Old: old, New: &c, ChangedFields: fields}
+}
+
+// 回调Handler，在dispatcher的goroutine中执行
+func (bot *Bot) deliver(e *event) {
+  switch e.kind {
+  case eventContact:
+    defer bot.recoverCallback("OnContact", nil, e.contact.Contact())
+    bot.onContact(e.contact)
+  case eventGroup:
+    defer bot.recoverCallback("OnGroupEvent", e.group.Msg, e.group.Group)
+    bot.onGroup(e.group)
+  case eventSelector:
+    defer bot.recoverCallback("OnSelector", nil, nil)
+    bot.onSelector(e.selector)
+  case eventMessage:
+    defer bot.recoverCallback("OnMessage", e.msg, nil)
+    if ok := bot.processVerifyMsg(e.msg); ok {
+      return
     }
-    if ok := bot.processGroupMsg(m); ok {
-      continue
+    if ok := bot.processGroupMsg(e.msg); ok {
+      return
     }
-    bot.handler.OnMessage(m, 0)
+    bot.autoSaveMedia(e.msg)
+    bot.onMessage(e.msg)
   }
 }
 
@@ -88,7 +190,7 @@ func (bot *Bot) processVerifyMsg(msg *Message) bool {
     if u != "" && t != "" {
       c, _ := bot.Accept(u, t)
       if c != nil {
-        bot.handler.OnContact(c, 0)
+        bot.onContact(&ContactEvent{Kind: ContactAdded, New: c})
         return true
       }
     }
@@ -0,0 +1,39 @@
+package wxweb
+
+import (
+  "testing"
+)
+
+func TestDispatchChatRoomMemberList(t *testing.T) {
+  tests := []struct {
+    name      string
+    list      string
+    wantAdded []string
+    wantSkip  []string
+  }{
+    {"group", `[{"UserName":"@@g","NickName":"g","MemberCount":1,"MemberList":[{"UserName":"@m","NickName":"m"}]}]`, []string{"@@g"}, []string{"@m"}},
+    {"member only", `[{"UserName":"@m","NickName":"m"}]`, nil, []string{"@m"}},
+    {"mixed", `[{"UserName":"@m","NickName":"m"},{"UserName":"@@h","NickName":"h"}]`, []string{"@@h"}, []string{"@m"}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      bot := newTestBot(t, failingTransport(), WithDispatchPolicy(DispatchPolicy{Workers: 0}))
+      h := &testHandler{}
+      startTestDispatcher(t, bot, h)
+      bot.dispatch([]byte(`{"ModChatRoomMemberList":` + tt.list + `}`))
+      for _, v := range tt.wantAdded {
+        if bot.contacts.Get(v) == nil {
+          t.Errorf("%s not added", v)
+        }
+      }
+      for _, v := range tt.wantSkip {
+        if bot.contacts.Get(v) != nil {
+          t.Errorf("%s should not be a contact", v)
+        }
+      }
+      if len(h.contacts) != len(tt.wantAdded) {
+        t.Errorf("got %d contact events, want %d", len(h.contacts), len(tt.wantAdded))
+      }
+    })
+  }
+}
@@ -0,0 +1,476 @@
+package wxweb
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "hash/fnv"
+  "os"
+  "path"
+  "strconv"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+// 队列满时的处理方式
+const (
+  // 等待队列有空位（会阻塞synccheck/sync）
+  OverflowBlock = iota
+
+  // 丢弃最早的事件
+  OverflowDropOldest
+
+  // 写到磁盘（rootDir/uin/spill），队列有空位后再按顺序读回来
+  OverflowSpill
+)
+
+// 回调（OnMessage/OnContact）的分发策略，
+// 回调在Workers个goroutine中并发执行，同一个会话（好友或群）的事件始终在同一个goroutine中按顺序执行，
+// 这样回调中的耗时操作（如上传视频）不会阻塞synccheck
+type DispatchPolicy struct {
+  // goroutine的数量，0表示在sync的goroutine中直接回调（回调耗时太长会被服务器下线）
+  Workers int
+
+  // 每个goroutine的队列长度，默认256
+  QueueSize int
+
+  // 每个回调的预期最长执行时间，超时后只记录次数（DispatchStats.TimedOut）并打印日志，
+  // 不会中断回调，同一个会话的下一个事件仍然等待回调返回后再处理，
+  // 0表示不检查
+  Timeout time.Duration
+
+  // 队列满时的处理方式，默认OverflowBlock
+  Overflow int
+}
+
+var defaultDispatchPolicy = DispatchPolicy{
+  Workers:   4,
+  QueueSize: 256,
+  Overflow:  OverflowBlock,
+}
+
+func (bot *Bot) SetDispatchPolicy(policy DispatchPolicy) {
+  if policy.Workers < 0 {
+    policy.Workers = 0
+  }
+  if policy.QueueSize <= 0 {
+    policy.QueueSize = defaultDispatchPolicy.QueueSize
+  }
+  if policy.Overflow < OverflowBlock || policy.Overflow > OverflowSpill {
+    policy.Overflow = OverflowBlock
+  }
+  bot.dispatchPolicy = policy
+}
+
+// 分发统计
+type DispatchStats struct {
+  Workers int
+
+  // 当前正在排队的事件数（包括写到磁盘的）
+  Queued int
+
+  // 已经回调的事件数
+  Dispatched uint64
+
+  // 队列满时丢弃的事件数（OverflowDropOldest）
+  Dropped uint64
+
+  // 写到磁盘的事件数（OverflowSpill）
+  Spilled uint64
+
+  // 回调超时的次数
+  TimedOut uint64
+}
+
+func (bot *Bot) DispatchStats() DispatchStats {
+  bot.mu.Lock()
+  d := bot.dispatcher
+  bot.mu.Unlock()
+  if d == nil {
+    return DispatchStats{}
+  }
+  ret := DispatchStats{
+    Workers:    len(d.queues),
+    Dispatched: atomic.LoadUint64(&d.dispatched),
+    Dropped:    atomic.LoadUint64(&d.dropped),
+    Spilled:    atomic.LoadUint64(&d.spilled),
+    TimedOut:   atomic.LoadUint64(&d.timedOut),
+  }
+  for _, q := range d.queues {
+    ret.Queued += q.len()
+  }
+  return ret
+}
+
+const (
+  eventMessage = iota + 1
+  eventContact
+  eventGroup
+  eventSelector
+)
+
+type event struct {
+  kind     int
+  msg      *Message
+  contact  *ContactEvent
+  group    *GroupEvent
+  selector int
+}
+
+// 同一个会话的事件使用相同的key
+func (e *event) key(self string) string {
+  switch e.kind {
+  case eventMessage:
+    if e.msg.FromUserName == self {
+      return e.msg.ToUserName
+    }
+    return e.msg.FromUserName
+  case eventContact:
+    return e.contact.Contact().UserName
+  case eventGroup:
+    return e.group.GroupUserName
+  }
+  return ""
+}
+
+type dispatcher struct {
+  dispatched uint64
+  dropped    uint64
+  spilled    uint64
+  timedOut   uint64
+
+  bot    *Bot
+  policy DispatchPolicy
+  queues []*eventQueue
+  wg     sync.WaitGroup
+}
+
+// 登录成功后启动，下线后由sync的goroutine调用close，
+// 队列中剩下的事件（包括写到磁盘的）回调完之后goroutine才退出
+func (bot *Bot) startDispatcher() {
+  p := bot.dispatchPolicy
+  d := &dispatcher{bot: bot, policy: p}
+  bot.mu.Lock()
+  bot.dispatcher = d
+  bot.mu.Unlock()
+  if p.Workers <= 0 {
+    return
+  }
+  dir := path.Join(bot.rootDir, strconv.FormatInt(bot.session.Uin, 10), "spill")
+  d.queues = make([]*eventQueue, p.Workers)
+  for i := range d.queues {
+    q := newEventQueue(d, p.QueueSize, path.Join(dir, fmt.Sprintf("%d.jsonl", i)))
+    d.queues[i] = q
+    d.wg.Add(1)
+    if !bot.spawn(func() { d.work(q) }) {
+      d.wg.Done()
+    }
+  }
+}
+
+// 不再接收新的事件，等待队列中剩下的事件都回调完，
+// 必须在最后一次post之后调用（即sync的goroutine退出时）
+func (d *dispatcher) close() {
+  for _, q := range d.queues {
+    q.close()
+  }
+  d.wg.Wait()
+}
+
+func (d *dispatcher) post(e *event) {
+  if len(d.queues) == 0 {
+    d.handle(e)
+    return
+  }
+  h := fnv.New32a()
+  h.Write([]byte(e.key(d.bot.session.UserName)))
+  d.queues[h.Sum32()%uint32(len(d.queues))].push(e)
+}
+
+func (d *dispatcher) work(q *eventQueue) {
+  defer d.wg.Done()
+  for {
+    e, ok := q.pop()
+    if !ok {
+      return
+    }
+    d.handle(e)
+  }
+}
+
+func (d *dispatcher) handle(e *event) {
+  atomic.AddUint64(&d.dispatched, 1)
+  if d.policy.Timeout <= 0 {
+    d.bot.deliver(e)
+    return
+  }
+  // 超时只记录（TimedOut）并打印日志，仍然等待回调返回再处理下一个事件，保证同一个会话的顺序
+  t := time.AfterFunc(d.policy.Timeout, func() {
+    atomic.AddUint64(&d.timedOut, 1)
+    d.bot.logger.Printf("wxweb: callback timeout after %v", d.policy.Timeout)
+  })
+  defer t.Stop()
+  d.bot.deliver(e)
+}
+
+type eventQueue struct {
+  d *dispatcher
+
+  mu     sync.Mutex
+  cond   *sync.Cond
+  items  []*event
+  size   int
+  closed bool
+
+  spill *spillFile
+}
+
+func newEventQueue(d *dispatcher, size int, spillPath string) *eventQueue {
+  q := &eventQueue{d: d, items: make([]*event, 0, size), size: size}
+  q.cond = sync.NewCond(&q.mu)
+  if d.policy.Overflow == OverflowSpill {
+    q.spill = &spillFile{path: spillPath}
+  }
+  return q
+}
+
+func (q *eventQueue) push(e *event) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  for !q.closed && len(q.items) >= q.size && q.d.policy.Overflow == OverflowBlock {
+    q.cond.Wait()
+  }
+  if q.closed {
+    atomic.AddUint64(&q.d.dropped, 1)
+    return
+  }
+  // 已经有写到磁盘的事件时，后面的事件也要写到磁盘，保证顺序
+  if q.spill != nil && (q.spill.n > 0 || len(q.items) >= q.size) {
+    if err := q.spill.push(e); err != nil {
+      q.d.bot.logger.Printf("wxweb: spill event failed: %v", err)
+      atomic.AddUint64(&q.d.dropped, 1)
+      return
+    }
+    atomic.AddUint64(&q.d.spilled, 1)
+    q.cond.Broadcast()
+    return
+  }
+  if len(q.items) >= q.size {
+    q.items[0] = nil
+    q.items = q.items[1:]
+    atomic.AddUint64(&q.d.dropped, 1)
+  }
+  q.items = append(q.items, e)
+  q.cond.Broadcast()
+}
+
+func (q *eventQueue) pop() (*event, bool) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  for {
+    if len(q.items) > 0 {
+      e := q.items[0]
+      q.items[0] = nil
+      q.items = q.items[1:]
+      q.cond.Broadcast()
+      return e, true
+    }
+    if q.spill != nil && q.spill.n > 0 {
+      e, err := q.spill.pop(q.d.bot)
+      if err != nil {
+        q.d.bot.logger.Printf("wxweb: read spilled event failed: %v", err)
+        continue
+      }
+      return e, true
+    }
+    // 关闭之后取完剩下的事件才退出
+    if q.closed {
+      return nil, false
+    }
+    q.cond.Wait()
+  }
+}
+
+func (q *eventQueue) len() int {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  n := len(q.items)
+  if q.spill != nil {
+    n += q.spill.n
+  }
+  return n
+}
+
+func (q *eventQueue) close() {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  q.closed = true
+  q.cond.Broadcast()
+}
+
+// 写到磁盘的事件（每行一个），只保存原始数据，读回来时重新解析
+type spillFile struct {
+  path string
+  w    *os.File
+  rf   *os.File
+  r    *bufio.Reader
+  n    int
+}
+
+type spillRecord struct {
+  Kind int             `json:"kind"`
+  Raw  json.RawMessage `json:"raw,omitempty"`
+
+  // ContactEvent
+  ContactKind   int             `json:"contact_kind,omitempty"`
+  Old           json.RawMessage `json:"old,omitempty"`
+  ChangedFields []string        `json:"changed_fields,omitempty"`
+
+  // GroupEvent（Raw是群，Msg是系统消息）
+  Group *spillGroupEvent `json:"group,omitempty"`
+
+  Selector int `json:"selector,omitempty"`
+}
+
+type spillGroupEvent struct {
+  Kind          int             `json:"kind"`
+  GroupUserName string          `json:"group_user_name"`
+  Members       []GroupMember   `json:"members,omitempty"`
+  OldName       string          `json:"old_name,omitempty"`
+  NewName       string          `json:"new_name,omitempty"`
+  OldOwner      string          `json:"old_owner,omitempty"`
+  NewOwner      string          `json:"new_owner,omitempty"`
+  Msg           json.RawMessage `json:"msg,omitempty"`
+}
+
+func (s *spillFile) push(e *event) error {
+  rec := spillRecord{Kind: e.kind, Selector: e.selector}
+  switch e.kind {
+  case eventMessage:
+    rec.Raw = e.msg.raw
+  case eventContact:
+    rec.ContactKind = e.contact.Kind
+    rec.ChangedFields = e.contact.ChangedFields
+    if e.contact.New != nil {
+      rec.Raw = e.contact.New.raw
+    }
+    if e.contact.Old != nil {
+      rec.Old = e.contact.Old.raw
+    }
+  case eventGroup:
+    g := e.group
+    rec.Group = &spillGroupEvent{
+      Kind:          g.Kind,
+      GroupUserName: g.GroupUserName,
+      Members:       g.Members,
+      OldName:       g.OldName,
+      NewName:       g.NewName,
+      OldOwner:      g.OldOwner,
+      NewOwner:      g.NewOwner,
+    }
+    if g.Group != nil {
+      rec.Raw = g.Group.raw
+    }
+    if g.Msg != nil {
+      rec.Group.Msg = g.Msg.raw
+    }
+  }
+  buf, err := json.Marshal(rec)
+  if err != nil {
+    return err
+  }
+  if s.w == nil {
+    if err = os.MkdirAll(path.Dir(s.path), os.ModePerm); err != nil {
+      return err
+    }
+    s.w, err = os.OpenFile(s.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+      return err
+    }
+  }
+  if _, err = s.w.Write(append(buf, '\n')); err != nil {
+    return err
+  }
+  s.n++
+  return nil
+}
+
+func (s *spillFile) pop(bot *Bot) (*event, error) {
+  if s.rf == nil {
+    f, err := os.Open(s.path)
+    if err != nil {
+      s.remove()
+      return nil, err
+    }
+    s.rf = f
+    s.r = bufio.NewReader(f)
+  }
+  line, err := s.r.ReadBytes('\n')
+  if err != nil {
+    // 文件已损坏，剩下的都丢弃
+    s.remove()
+    return nil, err
+  }
+  s.n--
+  if s.n <= 0 {
+    s.remove()
+  }
+  rec := spillRecord{}
+  if err = json.Unmarshal(line, &rec); err != nil {
+    return nil, err
+  }
+  e := &event{kind: rec.Kind}
+  switch rec.Kind {
+  case eventMessage:
+    e.msg = buildMessage(rec.Raw, bot)
+    if e.msg == nil {
+      return nil, ErrResp
+    }
+  case eventContact:
+    e.contact = &ContactEvent{
+      Kind:          rec.ContactKind,
+      Old:           buildContact(rec.Old, bot),
+      New:           buildContact(rec.Raw, bot),
+      ChangedFields: rec.ChangedFields,
+    }
+    if e.contact.Contact() == nil {
+      return nil, ErrResp
+    }
+  case eventGroup:
+    g := rec.Group
+    if g == nil {
+      return nil, ErrResp
+    }
+    e.group = &GroupEvent{
+      Kind:          g.Kind,
+      Group:         buildContact(rec.Raw, bot),
+      GroupUserName: g.GroupUserName,
+      Members:       g.Members,
+      OldName:       g.OldName,
+      NewName:       g.NewName,
+      OldOwner:      g.OldOwner,
+      NewOwner:      g.NewOwner,
+      Msg:           buildMessage(g.Msg, bot),
+    }
+  case eventSelector:
+    e.selector = rec.Selector
+  default:
+    return nil, ErrResp
+  }
+  return e, nil
+}
+
+func (s *spillFile) remove() {
+  if s.w != nil {
+    s.w.Close()
+    s.w = nil
+  }
+  if s.rf != nil {
+    s.rf.Close()
+    s.rf = nil
+    s.r = nil
+  }
+  s.n = 0
+  os.Remove(s.path)
+}
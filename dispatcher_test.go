@@ -0,0 +1,162 @@
+package wxweb
+
+import (
+  "path"
+  "testing"
+  "time"
+)
+
+func testMessage(bot *Bot, id, from string) *Message {
+  return buildMessage([]byte(`{"MsgId":"`+id+`","FromUserName":"`+from+`","ToUserName":"@self","MsgType":1,"Content":"hi"}`), bot)
+}
+
+func TestSpillFile(t *testing.T) {
+  bot := newTestBot(t, failingTransport())
+  group := buildContact([]byte(`{"UserName":"@@g","NickName":"g"}`), bot)
+  tests := []struct {
+    name  string
+    e     *event
+    check func(*event) bool
+  }{
+    {"message", &event{kind: eventMessage, msg: testMessage(bot, "1", "@a")}, func(e *event) bool {
+      return e.msg.Id == "1" && e.msg.FromUserName == "@a" && e.msg.Content == "hi"
+    }},
+    {"contact", &event{kind: eventContact, contact: &ContactEvent{Kind: ContactModified, Old: group, New: group, ChangedFields: []string{"NickName"}}}, func(e *event) bool {
+      return e.contact.Kind == ContactModified && e.contact.New.UserName == "@@g" && e.contact.Old.NickName == "g" && len(e.contact.ChangedFields) == 1
+    }},
+    {"contact deleted", &event{kind: eventContact, contact: &ContactEvent{Kind: ContactDeleted, Old: group}}, func(e *event) bool {
+      return e.contact.Kind == ContactDeleted && e.contact.Contact().UserName == "@@g" && e.contact.New == nil
+    }},
+    {"group", &event{kind: eventGroup, group: &GroupEvent{Kind: GroupRenamed, Group: group, GroupUserName: "@@g", OldName: "f", NewName: "g", Msg: testMessage(bot, "2", "@@g")}}, func(e *event) bool {
+      g := e.group
+      return g.Kind == GroupRenamed && g.Group.UserName == "@@g" && g.OldName == "f" && g.NewName == "g" && g.Msg.Id == "2"
+    }},
+    {"selector", &event{kind: eventSelector, selector: SelectorPhone}, func(e *event) bool {
+      return e.selector == SelectorPhone
+    }},
+  }
+  s := &spillFile{path: path.Join(tempDir(t), "spill", "0.jsonl")}
+  for _, tt := range tests {
+    if e := s.push(tt.e); e != nil {
+      t.Fatalf("push %s: %v", tt.name, e)
+    }
+  }
+  if s.n != len(tests) {
+    t.Fatalf("n = %d, want %d", s.n, len(tests))
+  }
+  for _, tt := range tests {
+    e, err := s.pop(bot)
+    if err != nil {
+      t.Fatalf("pop %s: %v", tt.name, err)
+    }
+    if e.kind != tt.e.kind || !tt.check(e) {
+      t.Errorf("pop %s = %+v", tt.name, e)
+    }
+  }
+  if s.n != 0 || s.w != nil || s.rf != nil {
+    t.Errorf("spill file not removed after all events are read")
+  }
+}
+
+type gateHandler struct {
+  *testHandler
+  gate chan struct{}
+}
+
+func (h *gateHandler) OnMessage(msg *Message, n int) {
+  <-h.gate
+  h.testHandler.OnMessage(msg, n)
+}
+
+func startTestDispatcher(t *testing.T, bot *Bot, h Handler) {
+  t.Helper()
+  bot.handler = h
+  if !bot.setState(StateRunning) {
+    t.Fatalf("state %d", bot.State())
+  }
+  bot.seen = newSeenSet(bot.dedupPolicy)
+  bot.startDispatcher()
+}
+
+func TestDispatcherDrainsOnSignOut(t *testing.T) {
+  tests := []struct {
+    name     string
+    overflow int
+  }{
+    {"block", OverflowBlock},
+    {"spill", OverflowSpill},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      bot := newTestBot(t, failingTransport(), WithDispatchPolicy(DispatchPolicy{Workers: 1, QueueSize: 4, Overflow: tt.overflow}))
+      h := &gateHandler{&testHandler{}, make(chan struct{})}
+      startTestDispatcher(t, bot, h)
+      const n = 10
+      posted := make(chan struct{})
+      go func() {
+        defer close(posted)
+        for i := 0; i < n; i++ {
+          bot.dispatcher.post(&event{kind: eventMessage, msg: testMessage(bot, string(rune('a'+i)), "@a")})
+        }
+      }()
+      if tt.overflow != OverflowBlock {
+        <-posted
+      }
+      close(h.gate)
+      <-posted
+      (&syncReq{bot}).signOut()
+      h.mu.Lock()
+      defer h.mu.Unlock()
+      if len(h.messages) != n {
+        t.Fatalf("got %d messages, want %d", len(h.messages), n)
+      }
+      for i, m := range h.messages {
+        if m.Id != string(rune('a'+i)) {
+          t.Errorf("message %d id = %s", i, m.Id)
+        }
+      }
+      if h.signOut != 1 {
+        t.Errorf("signOut = %d, want 1", h.signOut)
+      }
+      waitDone(t, bot)
+    })
+  }
+}
+
+// 回调超时后只记录次数，同一个会话的下一个事件仍然等待回调返回，Done也要等待回调返回
+func TestDispatcherTimeoutKeepsOrder(t *testing.T) {
+  bot := newTestBot(t, failingTransport(), WithDispatchPolicy(DispatchPolicy{Workers: 1, QueueSize: 4, Timeout: time.Millisecond * 10}))
+  h := &gateHandler{&testHandler{}, make(chan struct{})}
+  startTestDispatcher(t, bot, h)
+  bot.dispatcher.post(&event{kind: eventMessage, msg: testMessage(bot, "a", "@a")})
+  bot.dispatcher.post(&event{kind: eventMessage, msg: testMessage(bot, "b", "@a")})
+  deadline := time.Now().Add(time.Second * 5)
+  for bot.DispatchStats().TimedOut == 0 {
+    if time.Now().After(deadline) {
+      t.Fatal("callback not timed out")
+    }
+    time.Sleep(time.Millisecond)
+  }
+  go (&syncReq{bot}).signOut()
+  select {
+  case <-bot.Done():
+    t.Fatal("done closed while a timed out callback is still running")
+  case <-time.After(time.Millisecond * 50):
+  }
+  h.mu.Lock()
+  n := len(h.messages)
+  h.mu.Unlock()
+  if n != 0 {
+    t.Fatalf("got %d messages before the timed out callback returned, want 0", n)
+  }
+  close(h.gate)
+  waitDone(t, bot)
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  if len(h.messages) != 2 || h.messages[0].Id != "a" || h.messages[1].Id != "b" {
+    t.Fatalf("messages = %v, want [a b]", h.messages)
+  }
+  if n := bot.DispatchStats().TimedOut; n != 1 {
+    t.Errorf("TimedOut = %d, want 1", n)
+  }
+}
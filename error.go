@@ -0,0 +1,117 @@
+package wxweb
+
+import (
+  "errors"
+  "fmt"
+  "strings"
+
+  "github.com/buger/jsonparser"
+)
+
+// 登录流程的各个阶段（也是signInPipeline中Handler的名字）
+const (
+  StageResume   = "resume"
+  StageQR       = "qr"
+  StageScan     = "scan"
+  StageRedirect = "redirect"
+  StageInit     = "init"
+  StageNotify   = "notify"
+  StageContacts = "contacts"
+  StageSync     = "sync"
+)
+
+// 服务器返回的已知错误码
+var (
+  // 1100，凭据无效（未登录或已在其他地方登录）
+  ErrSessionInvalid = errors.New("session invalid")
+
+  // 1101，已退出（在手机上退出了Web微信或长时间没有synccheck）
+  ErrSignedOut = errors.New("signed out")
+
+  // 1102，凭据过期
+  ErrSessionExpired = errors.New("session expired")
+
+  // 1203，该帐号不能登录Web微信（新注册、长时间未使用或被限制）
+  ErrWebLoginForbidden = errors.New("web login forbidden")
+
+  // 1205，操作太频繁
+  ErrTooFrequent = errors.New("too frequent")
+)
+
+var retErrors = map[int]error{
+  1100: ErrSessionInvalid,
+  1101: ErrSignedOut,
+  1102: ErrSessionExpired,
+  1203: ErrWebLoginForbidden,
+  1205: ErrTooFrequent,
+}
+
+// 登录失败时OnSignIn收到的错误，
+// 可以用errors.Is判断是否是已知错误码（如ErrWebLoginForbidden），
+// 或者是请求失败（ErrReq）、超时（base.ErrTimeout）等
+type SignInError struct {
+  // 失败的阶段，如StageRedirect
+  Stage string
+
+  // 服务器返回的错误码和错误信息（如果有）
+  Ret     int
+  Message string
+
+  Cause error
+}
+
+func (e *SignInError) Error() string {
+  var sb strings.Builder
+  sb.WriteString("sign in failed")
+  if e.Stage != "" {
+    sb.WriteString(" at ")
+    sb.WriteString(e.Stage)
+  }
+  if e.Ret != 0 {
+    fmt.Fprintf(&sb, " (ret %d", e.Ret)
+    if e.Message != "" {
+      sb.WriteString(", ")
+      sb.WriteString(e.Message)
+    }
+    sb.WriteString(")")
+  }
+  if e.Cause != nil {
+    sb.WriteString(": ")
+    sb.WriteString(e.Cause.Error())
+  }
+  return sb.String()
+}
+
+func (e *SignInError) Unwrap() error {
+  return e.Cause
+}
+
+// 服务器返回了非0错误码，Stage由signInError设置
+func retError(ret int, message string) *SignInError {
+  cause, ok := retErrors[ret]
+  if !ok {
+    cause = ErrResp
+  }
+  return &SignInError{Ret: ret, Message: message, Cause: cause}
+}
+
+func signInError(stage string, e error) *SignInError {
+  var se *SignInError
+  if errors.As(e, &se) {
+    if se.Stage == "" {
+      se.Stage = stage
+    }
+    return se
+  }
+  return &SignInError{Stage: stage, Cause: e}
+}
+
+// BaseResponse.Ret不为0时返回*SignInError，没有BaseResponse时不检查
+func checkBaseResp(body []byte) error {
+  ret, e := jsonparser.GetInt(body, "BaseResponse", "Ret")
+  if e != nil || ret == 0 {
+    return nil
+  }
+  msg, _ := jsonparser.GetString(body, "BaseResponse", "ErrMsg")
+  return retError(int(ret), msg)
+}
@@ -0,0 +1,223 @@
+package wxweb
+
+import (
+  "sync"
+  "time"
+)
+
+// Event的类型
+const (
+  EventQRCode = iota + 1
+  EventSignIn
+  EventSignOut
+  EventContact
+  EventGroup
+  EventMessage
+  EventSelector
+)
+
+// Events返回的事件，与Handler的回调一一对应
+type Event struct {
+  Type int
+  Bot  *Bot
+  Time time.Time
+
+  // 二维码链接（EventQRCode）
+  QRCodeUrl string
+
+  // 登录失败的原因（EventSignIn，nil表示登录成功），
+  // 下线的原因（EventSignOut，与Bot.Err相同）
+  Err error
+
+  // EventContact
+  Contact *ContactEvent
+
+  // EventGroup
+  Group *GroupEvent
+
+  // EventMessage
+  Message *Message
+
+  // synccheck返回的selector（EventSelector，不包括SelectorMessage）
+  Selector int
+}
+
+// Events的缓冲区和背压策略
+type EventsPolicy struct {
+  // 缓冲区大小，默认256
+  Buffer int
+
+  // 缓冲区满时的处理方式，
+  // OverflowBlock（默认）：等待读取（会阻塞回调，读取太慢时同一个会话的后续事件也会等待，
+  // 下线后最多等待StopTimeout，超时的事件被丢弃），
+  // OverflowDropOldest：丢弃最早的事件，
+  // 不支持OverflowSpill（当作OverflowBlock）
+  Overflow int
+}
+
+var defaultEventsPolicy = EventsPolicy{
+  Buffer:   256,
+  Overflow: OverflowBlock,
+}
+
+func (bot *Bot) SetEventsPolicy(policy EventsPolicy) {
+  if policy.Buffer <= 0 {
+    policy.Buffer = defaultEventsPolicy.Buffer
+  }
+  if policy.Overflow != OverflowDropOldest {
+    policy.Overflow = OverflowBlock
+  }
+  bot.eventsPolicy = policy
+}
+
+type eventStream struct {
+  mu      sync.RWMutex
+  ch      chan Event
+  closing chan struct{}
+  closed  bool
+
+  // 下线后所有emit共用的最长等待时间（StopTimeout）
+  once   sync.Once
+  expire chan struct{}
+}
+
+func (s *eventStream) expired(timeout time.Duration) <-chan struct{} {
+  s.once.Do(func() {
+    s.expire = make(chan struct{})
+    time.AfterFunc(timeout, func() { close(s.expire) })
+  })
+  return s.expire
+}
+
+// 事件流，可以与Handler同时使用，也可以不使用Handler（Start时传nil），
+// 需要在Start之前调用才能收到EventQRCode和EventSignIn，
+// Bot下线并且所有回调都返回后（Done关闭后）关闭
+func (bot *Bot) Events() <-chan Event {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  if bot.events == nil {
+    s := &eventStream{
+      ch:      make(chan Event, bot.eventsPolicy.Buffer),
+      closing: make(chan struct{}),
+    }
+    bot.events = s
+    go func() {
+      <-bot.done
+      close(s.closing)
+      s.mu.Lock()
+      defer s.mu.Unlock()
+      s.closed = true
+      close(s.ch)
+    }()
+  }
+  return bot.events.ch
+}
+
+func (bot *Bot) emit(e Event) {
+  bot.mu.Lock()
+  s := bot.events
+  bot.mu.Unlock()
+  if s == nil {
+    return
+  }
+  e.Bot = bot
+  e.Time = bot.now()
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  if s.closed {
+    return
+  }
+  if bot.eventsPolicy.Overflow == OverflowDropOldest {
+    for {
+      select {
+      case s.ch <- e:
+        return
+      default:
+      }
+      select {
+      case <-s.ch:
+      default:
+      }
+    }
+  }
+  select {
+  case s.ch <- e:
+    return
+  default:
+  }
+  select {
+  case s.ch <- e:
+    return
+  case <-s.closing:
+    return
+  case <-bot.ctx.Done():
+  }
+  // 已下线时（队列中剩下的事件和EventSignOut）继续等待读取，
+  // 但最多等待StopTimeout（等待的goroutine不返回，Done就不会关闭）
+  select {
+  case s.ch <- e:
+  case <-s.expired(bot.stopTimeout):
+  case <-s.closing:
+  }
+}
+
+// synccheck通知（可选），
+// Handler实现了该接口时，synccheck返回SelectorMessage以外的selector（如SelectorContact、SelectorPhone）时回调，
+// 对应的数据（如联系人变化）会在之后通过其他回调通知
+type SelectorHandler interface {
+  OnSelector(int)
+}
+
+// 没有传Handler时使用
+type nopHandler struct{}
+
+func (nopHandler) OnSignIn(error)          {}
+func (nopHandler) OnSignOut()              {}
+func (nopHandler) OnQRCode(string)         {}
+func (nopHandler) OnContact(*Contact, int) {}
+func (nopHandler) OnMessage(*Message, int) {}
+
+// 以下是所有回调的入口，先回调Handler再发送到Events
+
+func (bot *Bot) onQRCode(url string) {
+  bot.handler.OnQRCode(url)
+  bot.emit(Event{Type: EventQRCode, QRCodeUrl: url})
+}
+
+func (bot *Bot) onSignIn(e error) {
+  bot.handler.OnSignIn(e)
+  bot.emit(Event{Type: EventSignIn, Err: e})
+}
+
+func (bot *Bot) onSignOut() {
+  bot.handler.OnSignOut()
+  bot.emit(Event{Type: EventSignOut, Err: bot.Err()})
+}
+
+func (bot *Bot) onContact(e *ContactEvent) {
+  if h, ok := bot.handler.(ContactEventHandler); ok {
+    h.OnContactEvent(e)
+  } else {
+    bot.handler.OnContact(e.Contact(), e.Kind)
+  }
+  bot.emit(Event{Type: EventContact, Contact: e})
+}
+
+func (bot *Bot) onGroup(e *GroupEvent) {
+  if h, ok := bot.handler.(GroupEventHandler); ok {
+    h.OnGroupEvent(e)
+  }
+  bot.emit(Event{Type: EventGroup, Group: e})
+}
+
+func (bot *Bot) onSelector(selector int) {
+  if h, ok := bot.handler.(SelectorHandler); ok {
+    h.OnSelector(selector)
+  }
+  bot.emit(Event{Type: EventSelector, Selector: selector})
+}
+
+func (bot *Bot) onMessage(msg *Message) {
+  bot.handler.OnMessage(msg, 0)
+  bot.emit(Event{Type: EventMessage, Message: msg})
+}
@@ -0,0 +1,68 @@
+package wxweb
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+// 不读取Events时，下线后emit最多等待StopTimeout，不能一直阻塞
+func TestEventsBlockedConsumerDoesNotHangStop(t *testing.T) {
+  bot := newTestBot(t, failingTransport(), WithEventsPolicy(EventsPolicy{Buffer: 1}))
+  ch := bot.Events()
+  bot.handler = &testHandler{}
+  bot.setState(StateRunning)
+  started := make(chan struct{})
+  bot.spawn(func() {
+    close(started)
+    for i := 0; i < 5; i++ {
+      bot.emit(Event{Type: EventMessage})
+    }
+  })
+  <-started
+  bot.Stop()
+  waitDone(t, bot)
+  n := 0
+  for range ch {
+    n++
+  }
+  if n < 1 {
+    t.Errorf("got %d events, want at least 1", n)
+  }
+}
+
+// 读取较慢时（缓冲区已满），下线后的事件（包括EventSignOut）不能被丢弃
+func TestEventsSlowConsumerReceivesSignOut(t *testing.T) {
+  bot := newTestBot(t, failingTransport(), WithEventsPolicy(EventsPolicy{Buffer: 1}))
+  ch := bot.Events()
+  bot.emit(Event{Type: EventSelector, Selector: SelectorContact})
+  startTestLoop(t, bot, &testHandler{})
+  select {
+  case <-bot.ctx.Done():
+  case <-time.After(time.Second * 5):
+    t.Fatal("bot not stopped")
+  }
+  time.Sleep(time.Millisecond * 50)
+  var types []int
+  for e := range ch {
+    types = append(types, e.Type)
+  }
+  want := []int{EventSelector, EventSignOut}
+  if !reflect.DeepEqual(types, want) {
+    t.Errorf("event types = %v, want %v", types, want)
+  }
+}
+
+func TestEventsDropOldest(t *testing.T) {
+  bot := newTestBot(t, failingTransport(), WithEventsPolicy(EventsPolicy{Buffer: 2, Overflow: OverflowDropOldest}))
+  ch := bot.Events()
+  for i := 1; i <= 5; i++ {
+    bot.emit(Event{Type: EventSelector, Selector: i})
+  }
+  if e := <-ch; e.Selector != 4 {
+    t.Errorf("first event selector = %d, want 4", e.Selector)
+  }
+  if e := <-ch; e.Selector != 5 {
+    t.Errorf("second event selector = %d, want 5", e.Selector)
+  }
+}
@@ -5,5 +5,6 @@ go 1.14
 require (
 	github.com/buger/jsonparser v1.0.0
 	github.com/kwf2030/commons v1.2.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/net v0.0.0-20200528225125-3c3fba18258b
 )
@@ -0,0 +1,224 @@
+package wxweb
+
+import (
+  "regexp"
+  "strings"
+)
+
+// 群事件的类型
+const (
+  // 有新成员加入
+  GroupMemberJoined = iota + 1
+
+  // 有成员退出或被移出
+  GroupMemberLeft
+
+  // 群改名
+  GroupRenamed
+
+  // 群主变更
+  GroupOwnerChanged
+)
+
+// 群事件（可选），
+// Handler实现了该接口时，群成员变化、群改名和群主变更都会回调OnGroupEvent（在OnContact之后），
+// 事件是对比本地缓存的群和ModContactList中的群得到的，
+// 没有收到群的更新时会从系统消息（MsgSystem）中解析
+type GroupEventHandler interface {
+  OnGroupEvent(*GroupEvent)
+}
+
+type GroupMember struct {
+  UserName string
+  NickName string
+}
+
+type GroupEvent struct {
+  Kind int
+
+  // 变化后的群（从系统消息解析时是本地缓存的群，可能为nil）
+  Group *Contact
+
+  // 群的UserName
+  GroupUserName string
+
+  // 加入或退出的成员（MemberJoined/MemberLeft），
+  // 从系统消息解析时只有NickName
+  Members []GroupMember
+
+  // 改名前后的群名称（GroupRenamed）
+  OldName string
+  NewName string
+
+  // 变更前后群主的UserName（GroupOwnerChanged），
+  // 从系统消息解析时，新群主不是自己且不在本地缓存的群成员中则NewOwner为空
+  OldOwner string
+  NewOwner string
+
+  // 从系统消息解析时为该消息，否则为nil
+  Msg *Message
+}
+
+// 对比群的变化，不是群或不是Modified时返回nil
+func groupEvents(ce *ContactEvent) []*GroupEvent {
+  if ce.Kind != ContactModified || ce.New.Type != ContactGroup {
+    return nil
+  }
+  old, c := ce.Old, ce.New
+  var ret []*GroupEvent
+  // 没有成员列表时（如初始化时的联系人，或者MemberCount不为0但是MemberList为空）无法对比
+  if len(old.Members) > 0 && len(c.Members) > 0 {
+    var joined, left []GroupMember
+    for k, v := range c.Members {
+      if _, ok := old.Members[k]; !ok {
+        joined = append(joined, GroupMember{k, v})
+      }
+    }
+    for k, v := range old.Members {
+      if _, ok := c.Members[k]; !ok {
+        left = append(left, GroupMember{k, v})
+      }
+    }
+    if len(joined) > 0 {
+      ret = append(ret, &GroupEvent{Kind: GroupMemberJoined, Group: c, GroupUserName: c.UserName, Members: joined})
+    }
+    if len(left) > 0 {
+      ret = append(ret, &GroupEvent{Kind: GroupMemberLeft, Group: c, GroupUserName: c.UserName, Members: left})
+    }
+  }
+  if old.NickName != c.NickName {
+    ret = append(ret, &GroupEvent{Kind: GroupRenamed, Group: c, GroupUserName: c.UserName, OldName: old.NickName, NewName: c.NickName})
+  }
+  if old.ChatRoomOwner != "" && c.ChatRoomOwner != "" && old.ChatRoomOwner != c.ChatRoomOwner {
+    ret = append(ret, &GroupEvent{Kind: GroupOwnerChanged, Group: c, GroupUserName: c.UserName, OldOwner: old.ChatRoomOwner, NewOwner: c.ChatRoomOwner})
+  }
+  return ret
+}
+
+var (
+  // "张三"邀请"李四、王五"加入了群聊 / 你邀请"李四"加入了群聊
+  groupInviteRegex = regexp.MustCompile(`^(?:你|"(.+?)")邀请"(.+)"加入了群聊`)
+
+  // "李四"通过扫描"张三"分享的二维码加入群聊
+  groupScanRegex = regexp.MustCompile(`^"(.+?)"通过扫描.*二维码加入群聊`)
+
+  // "张三" invited "李四" to the group chat / You invited "李四" to the group chat
+  groupInviteEnRegex = regexp.MustCompile(`^(?:You|"(.+?)") invited "(.+)" to the group chat`)
+
+  // 你将"李四"移出了群聊 / "李四"被移出了群聊
+  groupRemoveRegex = regexp.MustCompile(`^(?:你将"(.+)"移出了群聊|"(.+)"被移出了群聊)`)
+
+  // You removed "李四" from the group chat
+  groupRemoveEnRegex = regexp.MustCompile(`^You removed "(.+)" from the group chat`)
+
+  // "张三"修改群名为“新群名” / 你修改群名为“新群名”
+  groupRenameRegex = regexp.MustCompile(`^(?:你|"(.+?)")修改群名为“(.+)”`)
+
+  // "张三" changed the group name to "新群名"
+  groupRenameEnRegex = regexp.MustCompile(`changed the group name to "(.+)"`)
+
+  // 你已成为新群主 / "张三"已成为新群主
+  groupOwnerRegex = regexp.MustCompile(`^(?:你|"(.+?)")已成为新群主`)
+)
+
+// 从群的系统消息中解析群事件，
+// 如果本地缓存的群已经是变化后的样子（已经从ModContactList得到了事件），返回nil
+func (bot *Bot) parseGroupSystemMsg(msg *Message) *GroupEvent {
+  if msg.Type != MsgSystem || contactType(msg.FromUserName) != ContactGroup {
+    return nil
+  }
+  group := bot.Contacts().Get(msg.FromUserName)
+  e := &GroupEvent{Group: group, GroupUserName: msg.FromUserName, Msg: msg}
+  content := strings.TrimSpace(msg.Content)
+  if arr := groupInviteRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.Members = GroupMemberJoined, splitMemberNames(arr[2])
+  } else if arr := groupScanRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.Members = GroupMemberJoined, splitMemberNames(arr[1])
+  } else if arr := groupInviteEnRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.Members = GroupMemberJoined, splitMemberNames(arr[2])
+  } else if arr := groupRemoveRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.Members = GroupMemberLeft, splitMemberNames(arr[1]+arr[2])
+  } else if arr := groupRemoveEnRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.Members = GroupMemberLeft, splitMemberNames(arr[1])
+  } else if arr := groupRenameRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.NewName = GroupRenamed, arr[2]
+  } else if arr := groupRenameEnRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind, e.NewName = GroupRenamed, arr[1]
+  } else if arr := groupOwnerRegex.FindStringSubmatch(content); arr != nil {
+    e.Kind = GroupOwnerChanged
+    if arr[1] == "" {
+      e.NewOwner = bot.session.UserName
+    } else if group != nil {
+      e.NewOwner = memberUserName(group.Members, arr[1])
+    }
+  } else {
+    return nil
+  }
+  if group == nil {
+    return e
+  }
+  switch e.Kind {
+  case GroupMemberJoined:
+    if len(group.Members) > 0 && hasMembers(group.Members, e.Members) {
+      return nil
+    }
+  case GroupMemberLeft:
+    if len(group.Members) > 0 && !hasMembers(group.Members, e.Members) {
+      return nil
+    }
+  case GroupRenamed:
+    if group.NickName == e.NewName {
+      return nil
+    }
+    e.OldName = group.NickName
+  case GroupOwnerChanged:
+    if e.NewOwner != "" && group.ChatRoomOwner == e.NewOwner {
+      return nil
+    }
+    e.OldOwner = group.ChatRoomOwner
+  }
+  return e
+}
+
+func splitMemberNames(s string) []GroupMember {
+  var ret []GroupMember
+  for _, v := range strings.Split(s, "、") {
+    v = strings.Trim(v, `"`)
+    if v != "" {
+      ret = append(ret, GroupMember{NickName: v})
+    }
+  }
+  return ret
+}
+
+// 根据昵称查找群成员的UserName，找不到（或有重名）时返回空字符串
+func memberUserName(members map[string]string, nickName string) string {
+  ret := ""
+  for k, v := range members {
+    if v != nickName {
+      continue
+    }
+    if ret != "" {
+      return ""
+    }
+    ret = k
+  }
+  return ret
+}
+
+// 群成员中是否包含所有这些昵称
+func hasMembers(members map[string]string, arr []GroupMember) bool {
+  for _, m := range arr {
+    found := false
+    for _, v := range members {
+      if v == m.NickName {
+        found = true
+        break
+      }
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,101 @@
+package wxweb
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestGroupEvents(t *testing.T) {
+  group := func(name, owner string, members map[string]string) *Contact {
+    return &Contact{UserName: "@@g", Type: ContactGroup, NickName: name, ChatRoomOwner: owner, Members: members}
+  }
+  ab := map[string]string{"@a": "a", "@b": "b"}
+  bc := map[string]string{"@b": "b", "@c": "c"}
+  tests := []struct {
+    name string
+    e    *ContactEvent
+    want []int
+  }{
+    {"not modified", &ContactEvent{Kind: ContactAdded, New: group("g", "", ab)}, nil},
+    {"not group", &ContactEvent{Kind: ContactModified, Old: &Contact{NickName: "a"}, New: &Contact{Type: ContactFriend, NickName: "b"}}, nil},
+    {"members", &ContactEvent{Kind: ContactModified, Old: group("g", "", ab), New: group("g", "", bc)}, []int{GroupMemberJoined, GroupMemberLeft}},
+    {"old members unknown", &ContactEvent{Kind: ContactModified, Old: group("g", "", nil), New: group("g", "", bc)}, nil},
+    {"new members empty", &ContactEvent{Kind: ContactModified, Old: group("g", "", ab), New: group("g", "", map[string]string{})}, nil},
+    {"renamed", &ContactEvent{Kind: ContactModified, Old: group("g", "", ab), New: group("h", "", ab)}, []int{GroupRenamed}},
+    {"owner", &ContactEvent{Kind: ContactModified, Old: group("g", "@a", ab), New: group("g", "@b", ab)}, []int{GroupOwnerChanged}},
+    {"owner unknown", &ContactEvent{Kind: ContactModified, Old: group("g", "", ab), New: group("g", "@b", ab)}, nil},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      var got []int
+      for _, e := range groupEvents(tt.e) {
+        got = append(got, e.Kind)
+      }
+      if !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("groupEvents() kinds = %v, want %v", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestParseGroupSystemMsg(t *testing.T) {
+  cached := &Contact{
+    UserName:      "@@g",
+    Type:          ContactGroup,
+    NickName:      "群",
+    ChatRoomOwner: "@a",
+    Members:       map[string]string{"@a": "张三", "@b": "李四"},
+  }
+  tests := []struct {
+    name    string
+    from    string
+    content string
+    cached  bool
+    want    *GroupEvent
+  }{
+    {"not group", "@u", `"张三"邀请"王五"加入了群聊`, false, nil},
+    {"unknown", "@@g", "hello", false, nil},
+    {"invite", "@@g", `"张三"邀请"王五、赵六"加入了群聊`, false, &GroupEvent{Kind: GroupMemberJoined, Members: []GroupMember{{NickName: "王五"}, {NickName: "赵六"}}}},
+    {"invite by self", "@@g", `你邀请"王五"加入了群聊`, false, &GroupEvent{Kind: GroupMemberJoined, Members: []GroupMember{{NickName: "王五"}}}},
+    {"invite already applied", "@@g", `你邀请"李四"加入了群聊`, true, nil},
+    {"scan", "@@g", `"王五"通过扫描"张三"分享的二维码加入群聊`, false, &GroupEvent{Kind: GroupMemberJoined, Members: []GroupMember{{NickName: "王五"}}}},
+    {"invite en", "@@g", `You invited "王五" to the group chat`, false, &GroupEvent{Kind: GroupMemberJoined, Members: []GroupMember{{NickName: "王五"}}}},
+    {"remove", "@@g", `你将"李四"移出了群聊`, true, &GroupEvent{Kind: GroupMemberLeft, Members: []GroupMember{{NickName: "李四"}}}},
+    {"removed", "@@g", `"王五"被移出了群聊`, false, &GroupEvent{Kind: GroupMemberLeft, Members: []GroupMember{{NickName: "王五"}}}},
+    {"remove already applied", "@@g", `你将"王五"移出了群聊`, true, nil},
+    {"remove en", "@@g", `You removed "李四" from the group chat`, false, &GroupEvent{Kind: GroupMemberLeft, Members: []GroupMember{{NickName: "李四"}}}},
+    {"rename", "@@g", `"张三"修改群名为“新群”`, true, &GroupEvent{Kind: GroupRenamed, OldName: "群", NewName: "新群"}},
+    {"rename already applied", "@@g", `你修改群名为“群”`, true, nil},
+    {"rename en", "@@g", `"张三" changed the group name to "新群"`, false, &GroupEvent{Kind: GroupRenamed, NewName: "新群"}},
+    {"owner self", "@@g", `你已成为新群主`, true, &GroupEvent{Kind: GroupOwnerChanged, OldOwner: "@a", NewOwner: "@self"}},
+    {"owner member", "@@g", `"李四"已成为新群主`, true, &GroupEvent{Kind: GroupOwnerChanged, OldOwner: "@a", NewOwner: "@b"}},
+    {"owner already applied", "@@g", `"张三"已成为新群主`, true, nil},
+    {"owner not cached", "@@g", `"王五"已成为新群主`, true, &GroupEvent{Kind: GroupOwnerChanged, OldOwner: "@a"}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      bot := newTestBot(t, failingTransport())
+      if tt.cached {
+        bot.contacts.Add(cached)
+      }
+      got := bot.parseGroupSystemMsg(&Message{Type: MsgSystem, FromUserName: tt.from, Content: tt.content})
+      if tt.want == nil {
+        if got != nil {
+          t.Errorf("parseGroupSystemMsg() = %+v, want nil", got)
+        }
+        return
+      }
+      if got == nil {
+        t.Fatal("parseGroupSystemMsg() = nil")
+      }
+      if got.Kind != tt.want.Kind || !reflect.DeepEqual(got.Members, tt.want.Members) ||
+        got.OldName != tt.want.OldName || got.NewName != tt.want.NewName ||
+        got.OldOwner != tt.want.OldOwner || got.NewOwner != tt.want.NewOwner {
+        t.Errorf("parseGroupSystemMsg() = %+v, want %+v", got, tt.want)
+      }
+      if got.GroupUserName != tt.from || got.Msg == nil {
+        t.Errorf("GroupUserName = %q, Msg = %v", got.GroupUserName, got.Msg)
+      }
+    })
+  }
+}
@@ -0,0 +1,180 @@
+package wxweb
+
+import (
+  "context"
+  "errors"
+  "time"
+)
+
+const defaultWatchdogTimeout = time.Minute * 5
+
+// 超过WatchdogTimeout没有完成synccheck，且重连失败时下线的原因
+var ErrStalled = errors.New("sync loop stalled")
+
+// Bot的运行状况，
+// 正常情况下synccheck约25秒完成一次（即使没有新消息），
+// LastSyncCheck长时间没有更新说明连接已经卡住了
+type Health struct {
+  State int
+
+  // 最后一次完成synccheck的时间
+  LastSyncCheck time.Time
+
+  // 最后一次成功sync的时间
+  LastSync time.Time
+
+  // 最后一次收到消息的时间
+  LastMessage time.Time
+
+  // 连续失败的次数
+  ConsecutiveErrors int
+
+  // 最后一次synccheck返回的selector
+  Selector int
+}
+
+type health struct {
+  lastSyncCheck time.Time
+  lastSync      time.Time
+  lastMessage   time.Time
+  selector      int
+
+  // 正在重连时watchdog不检查
+  reconnecting bool
+
+  // 取消当前的synccheck（watchdog用），polling表示synccheck正在进行，
+  // stalled表示当前的synccheck是被watchdog取消的
+  pollCancel context.CancelFunc
+  polling    bool
+  stalled    bool
+}
+
+func (bot *Bot) Health() Health {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  return Health{
+    State:             bot.State(),
+    LastSyncCheck:     bot.health.lastSyncCheck,
+    LastSync:          bot.health.lastSync,
+    LastMessage:       bot.health.lastMessage,
+    ConsecutiveErrors: bot.syncErrors.Consecutive,
+    Selector:          bot.health.selector,
+  }
+}
+
+// 超过timeout没有完成synccheck时，
+// 先取消当前的synccheck并重连，再超过timeout还没有恢复就下线（Err返回ErrStalled），
+// 请求失败（正在退避）时不检查，由BackoffPolicy处理，
+// 0表示不检查，默认5分钟
+func (bot *Bot) SetWatchdogTimeout(timeout time.Duration) {
+  if timeout < 0 {
+    timeout = 0
+  }
+  bot.watchdogTimeout = timeout
+}
+
+func (bot *Bot) markSyncCheck(selector int) {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.health.lastSyncCheck = bot.now()
+  bot.health.selector = selector
+}
+
+func (bot *Bot) markSync(messages int) {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  now := bot.now()
+  bot.health.lastSync = now
+  if messages > 0 {
+    bot.health.lastMessage = now
+  }
+}
+
+func (bot *Bot) setReconnecting(reconnecting bool) {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.health.reconnecting = reconnecting
+  if !reconnecting {
+    // 重连结束后重新计时
+    bot.health.lastSyncCheck = bot.now()
+  }
+}
+
+// 返回synccheck用的ctx，watchdog可以单独取消它而不影响Bot，
+// synccheck返回后必须调用返回的CancelFunc（之后watchdog不会再取消）
+func (bot *Bot) pollContext() (context.Context, context.CancelFunc) {
+  ctx, cancel := context.WithCancel(bot.ctx)
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.health.pollCancel = cancel
+  bot.health.polling = true
+  bot.health.stalled = false
+  return ctx, func() {
+    bot.mu.Lock()
+    bot.health.polling = false
+    bot.health.pollCancel = nil
+    bot.mu.Unlock()
+    cancel()
+  }
+}
+
+// 当前的synccheck是否是被watchdog取消的
+func (bot *Bot) pollStalled() bool {
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  return bot.health.stalled
+}
+
+func (bot *Bot) watchdog() {
+  timeout := bot.watchdogTimeout
+  if timeout <= 0 {
+    return
+  }
+  ticker := time.NewTicker(timeout / 4)
+  defer ticker.Stop()
+  var kicked time.Time
+  for {
+    select {
+    case <-bot.ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    bot.mu.Lock()
+    now := bot.now()
+    last := bot.health.lastSyncCheck
+    if last.After(kicked) {
+      // 取消之后已经恢复了
+      kicked = time.Time{}
+    }
+    // 正在重连，或者请求失败正在退避（由BackoffPolicy熔断）
+    if bot.health.reconnecting || bot.syncErrors.Consecutive > 0 {
+      kicked = time.Time{}
+      bot.mu.Unlock()
+      continue
+    }
+    if now.Sub(last) < timeout {
+      bot.mu.Unlock()
+      continue
+    }
+    if kicked.IsZero() {
+      // 只取消正在进行的synccheck（卡住的长连接），由loop重连，
+      // 没有正在进行的synccheck时（如正在sync）等下次再检查
+      if !bot.health.polling {
+        bot.mu.Unlock()
+        continue
+      }
+      kicked = now
+      bot.health.stalled = true
+      bot.health.pollCancel()
+      bot.mu.Unlock()
+      bot.logger.Printf("wxweb: no synccheck completed since %v, recovering", last)
+      continue
+    }
+    bot.mu.Unlock()
+    if now.Sub(kicked) >= timeout {
+      bot.logger.Printf("wxweb: sync loop still stalled, signing out")
+      bot.shutdown(ErrStalled)
+      return
+    }
+  }
+}
@@ -0,0 +1,162 @@
+package wxweb
+
+import (
+  "sync"
+)
+
+// 默认的Manager，没有用WithManager指定时New创建的Bot都会加入，
+// 包级别的函数（EachBot、GetBotByUin、RunningBots、GetContact等）都使用它
+var defaultManager = NewManager()
+
+// 管理多个Bot，Bot下线时（Stop、登录失败或被动下线）自动移除，
+// 同一个进程中可以有多个Manager
+type Manager struct {
+  mu   sync.RWMutex
+  bots map[*Bot]struct{}
+}
+
+func NewManager() *Manager {
+  return &Manager{bots: make(map[*Bot]struct{}, 4)}
+}
+
+func DefaultManager() *Manager {
+  return defaultManager
+}
+
+// 已下线的Bot不会加入
+func (m *Manager) Add(bot *Bot) {
+  if bot == nil {
+    return
+  }
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  // 在锁内判断，shutdown在修改状态之后才会调用Remove
+  if bot.State() == StateStop {
+    return
+  }
+  m.bots[bot] = struct{}{}
+}
+
+func (m *Manager) Remove(bot *Bot) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  delete(m.bots, bot)
+}
+
+func (m *Manager) Each(f func(*Bot) bool) {
+  m.mu.RLock()
+  arr := make([]*Bot, 0, len(m.bots))
+  for k := range m.bots {
+    arr = append(arr, k)
+  }
+  m.mu.RUnlock()
+  for _, v := range arr {
+    if !f(v) {
+      break
+    }
+  }
+}
+
+func (m *Manager) Count() int {
+  m.mu.RLock()
+  defer m.mu.RUnlock()
+  return len(m.bots)
+}
+
+func (m *Manager) find(f func(*Bot) bool) *Bot {
+  var ret *Bot
+  m.Each(func(b *Bot) bool {
+    if f(b) {
+      ret = b
+      return false
+    }
+    return true
+  })
+  return ret
+}
+
+func (m *Manager) GetByUUID(uuid string) *Bot {
+  if uuid == "" {
+    return nil
+  }
+  return m.find(func(b *Bot) bool {
+    return b.sessionCopy().UUID == uuid
+  })
+}
+
+func (m *Manager) GetByUin(uin int64) *Bot {
+  if uin == 0 {
+    return nil
+  }
+  return m.find(func(b *Bot) bool {
+    return b.sessionCopy().Uin == uin
+  })
+}
+
+// 根据登录帐号的昵称查找（登录成功之后才有昵称）
+func (m *Manager) GetByNickName(nickName string) *Bot {
+  if nickName == "" {
+    return nil
+  }
+  return m.find(func(b *Bot) bool {
+    self := b.Self()
+    return self != nil && self.NickName == nickName
+  })
+}
+
+func (m *Manager) Running() []*Bot {
+  ret := make([]*Bot, 0, 4)
+  m.Each(func(b *Bot) bool {
+    if b.State() == StateRunning {
+      ret = append(ret, b)
+    }
+    return true
+  })
+  return ret
+}
+
+// 在所有Bot的联系人中查找
+func (m *Manager) GetContact(userName string) *Contact {
+  if userName == "" {
+    return nil
+  }
+  var ret *Contact
+  m.Each(func(b *Bot) bool {
+    if contacts := b.Contacts(); contacts != nil {
+      if c := contacts.Get(userName); c != nil {
+        ret = c
+        return false
+      }
+    }
+    return true
+  })
+  return ret
+}
+
+func EachBot(f func(*Bot) bool) {
+  defaultManager.Each(f)
+}
+
+func CountBots() int {
+  return defaultManager.Count()
+}
+
+func GetBotByUUID(uuid string) *Bot {
+  return defaultManager.GetByUUID(uuid)
+}
+
+func GetBotByUin(uin int64) *Bot {
+  return defaultManager.GetByUin(uin)
+}
+
+func GetBotByNickName(nickName string) *Bot {
+  return defaultManager.GetByNickName(nickName)
+}
+
+func RunningBots() []*Bot {
+  return defaultManager.Running()
+}
+
+func GetContact(userName string) *Contact {
+  return defaultManager.GetContact(userName)
+}
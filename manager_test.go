@@ -0,0 +1,95 @@
+package wxweb
+
+import (
+  "testing"
+  "time"
+
+  "github.com/kwf2030/commons/pipeline"
+)
+
+func TestManagerLookup(t *testing.T) {
+  m := NewManager()
+  a := newTestBot(t, failingTransport(), WithManager(m))
+  a.session.UUID = "uuid-a"
+  a.session.Uin = 1
+  a.setSelf(&Contact{bot: a, UserName: "@a", NickName: "a"})
+  b := newTestBot(t, failingTransport(), WithManager(m))
+  b.session.UUID = "uuid-b"
+  b.session.Uin = 2
+  b.setSelf(&Contact{bot: b, UserName: "@b", NickName: "b"})
+  tests := []struct {
+    name string
+    got  *Bot
+    want *Bot
+  }{
+    {"uuid", m.GetByUUID("uuid-b"), b},
+    {"uuid empty", m.GetByUUID(""), nil},
+    {"uuid unknown", m.GetByUUID("uuid-c"), nil},
+    {"uin", m.GetByUin(1), a},
+    {"uin zero", m.GetByUin(0), nil},
+    {"nickname", m.GetByNickName("b"), b},
+    {"nickname unknown", m.GetByNickName("c"), nil},
+  }
+  for _, tt := range tests {
+    if tt.got != tt.want {
+      t.Errorf("%s: got %p, want %p", tt.name, tt.got, tt.want)
+    }
+  }
+  if n := m.Count(); n != 2 {
+    t.Errorf("Count() = %d, want 2", n)
+  }
+}
+
+// 下线后应该立即从Manager中移除，而不是等到Done关闭
+func TestManagerRemoveOnStop(t *testing.T) {
+  m := NewManager()
+  bot := newTestBot(t, failingTransport(), WithManager(m))
+  bot.session.Uin = 3
+  bot.Stop()
+  if n := m.Count(); n != 0 {
+    t.Errorf("Count() = %d after Stop, want 0", n)
+  }
+  if m.GetByUin(3) != nil {
+    t.Error("GetByUin() found a stopped bot")
+  }
+  m.Add(bot)
+  if n := m.Count(); n != 0 {
+    t.Errorf("Count() = %d after adding a stopped bot, want 0", n)
+  }
+  bot.Release()
+  if m.GetByUin(3) != nil {
+    t.Error("GetByUin() found a released bot")
+  }
+}
+
+// Bot在登录之前就加入了Manager，获取联系人的同时在其他goroutine中查找，需要用go test -race运行
+func TestManagerGetContactDuringSignIn(t *testing.T) {
+  m := NewManager()
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "webwxgetcontact": `{"BaseResponse":{"Ret":0},"MemberCount":1,"MemberList":[{"UserName":"@a","NickName":"a"}]}`,
+  }), WithManager(m))
+  bot.setContacts(nil)
+  found := make(chan *Contact)
+  go func() {
+    for {
+      if c := m.GetContact("@a"); c != nil {
+        found <- c
+        return
+      }
+      msg := &Message{bot: bot, FromUserName: "@a", ToUserName: "@self"}
+      if c := msg.GetFromContact(); c != nil {
+        found <- c
+        return
+      }
+    }
+  }()
+  pipeline.New().AddLast(StageContacts, &contactsReq{bot}).Fire(nil)
+  select {
+  case c := <-found:
+    if c.NickName != "a" {
+      t.Errorf("NickName = %q, want a", c.NickName)
+    }
+  case <-time.After(time.Second * 5):
+    t.Fatalf("contact not found, Err() = %v", bot.Err())
+  }
+}
@@ -0,0 +1,252 @@
+package wxweb
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "path"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/buger/jsonparser"
+  "github.com/kwf2030/commons/base"
+)
+
+const (
+  getMsgImgUrlPath = "/webwxgetmsgimg"
+  getVoiceUrlPath  = "/webwxgetvoice"
+  getVideoUrlPath  = "/webwxgetvideo"
+  getMediaUrlPath  = "/webwxgetmedia"
+
+  // 自动保存的媒体文件路径
+  attrMediaPath = "wxweb.media_path"
+)
+
+// 自动保存时每个文件默认的最长下载时间
+const defaultAutoSaveTimeout = time.Minute
+
+var (
+  ErrNoMedia = errors.New("message has no media")
+
+  ErrMediaTooLarge = errors.New("media too large")
+)
+
+// 媒体文件（图片、语音、视频和文件）的下载策略
+type MediaPolicy struct {
+  // 最大下载大小（字节），超过时返回ErrMediaTooLarge，0表示不限制
+  MaxSize int64
+
+  // 是否自动保存收到的媒体文件（在OnMessage之前），
+  // 保存到rootDir/uin/日期/image（voice、video、file）目录，目录在第一次保存时创建，
+  // 保存的路径可以用Message.MediaPath获取
+  AutoSave bool
+
+  // 自动保存时每个文件的最长下载时间，超时后放弃保存（不影响回调OnMessage），默认1分钟
+  AutoSaveTimeout time.Duration
+}
+
+func (bot *Bot) SetMediaPolicy(policy MediaPolicy) {
+  if policy.MaxSize < 0 {
+    policy.MaxSize = 0
+  }
+  if policy.AutoSaveTimeout <= 0 {
+    policy.AutoSaveTimeout = defaultAutoSaveTimeout
+  }
+  bot.mediaPolicy = policy
+}
+
+// 是否有可以下载的媒体文件
+func (msg *Message) HasMedia() bool {
+  switch msg.Type {
+  case MsgImage, MsgVoice, MsgVideo, MsgVideoCall:
+    return true
+  case MsgLink:
+    t, _ := jsonparser.GetInt(msg.raw, "AppMsgType")
+    id, _ := jsonparser.GetString(msg.raw, "MediaId")
+    return t == AppMsgFile && id != ""
+  }
+  return false
+}
+
+// 自动保存的路径，没有保存时为空
+func (msg *Message) MediaPath() string {
+  return msg.GetAttrString(attrMediaPath, "")
+}
+
+// 下载媒体文件并写到w，返回写入的字节数
+func (msg *Message) Download(w io.Writer) (int64, error) {
+  return msg.download(msg.bot.ctx, w)
+}
+
+func (msg *Message) download(ctx context.Context, w io.Writer) (int64, error) {
+  if w == nil {
+    return 0, base.ErrInvalidArgument
+  }
+  if !msg.HasMedia() {
+    return 0, ErrNoMedia
+  }
+  req, e := msg.mediaRequest(ctx)
+  if e != nil {
+    return 0, e
+  }
+  bot := msg.bot
+  resp, e := bot.httpDo(req)
+  if e != nil {
+    return 0, e
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+    return 0, ErrReq
+  }
+  limit := bot.mediaPolicy.MaxSize
+  if limit <= 0 {
+    return io.Copy(w, resp.Body)
+  }
+  if resp.ContentLength > limit {
+    return 0, ErrMediaTooLarge
+  }
+  n, e := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+  if e != nil {
+    return n, e
+  }
+  if n > limit {
+    return n, ErrMediaTooLarge
+  }
+  return n, nil
+}
+
+// 下载媒体文件并保存到按天分的目录，返回保存的路径，
+// 下载失败时会删除已写入的部分
+func (msg *Message) SaveMedia() (string, error) {
+  return msg.saveMedia(msg.bot.ctx)
+}
+
+func (msg *Message) saveMedia(ctx context.Context) (string, error) {
+  if !msg.HasMedia() {
+    return "", ErrNoMedia
+  }
+  dir, name := msg.mediaFile()
+  if dir == "" {
+    return "", ErrInvalidState
+  }
+  if e := os.MkdirAll(dir, os.ModePerm); e != nil {
+    return "", e
+  }
+  dst := path.Join(dir, name)
+  f, e := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+  if e != nil {
+    return "", e
+  }
+  _, e = msg.download(ctx, f)
+  if e2 := f.Close(); e == nil {
+    e = e2
+  }
+  if e != nil {
+    os.Remove(dst)
+    return "", e
+  }
+  return dst, nil
+}
+
+// 媒体文件的目录和文件名
+func (msg *Message) mediaFile() (string, string) {
+  bot := msg.bot
+  id := msg.msgId()
+  switch msg.Type {
+  case MsgImage:
+    return bot.GetAttrString(attrImageDir, ""), id + ".jpg"
+  case MsgVoice:
+    return bot.GetAttrString(attrVoiceDir, ""), id + ".mp3"
+  case MsgVideo, MsgVideoCall:
+    return bot.GetAttrString(attrVideoDir, ""), id + ".mp4"
+  default:
+    name, _ := jsonparser.GetString(msg.raw, "FileName")
+    name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+    if name == "." || name == "/" || name == "" {
+      return bot.GetAttrString(attrFileDir, ""), id
+    }
+    return bot.GetAttrString(attrFileDir, ""), id + "_" + name
+  }
+}
+
+// 下载媒体文件使用的是MsgId（不是NewMsgId）
+func (msg *Message) msgId() string {
+  if id, e := jsonparser.GetString(msg.raw, "MsgId"); e == nil && id != "" {
+    return id
+  }
+  if id, e := jsonparser.GetInt(msg.raw, "MsgId"); e == nil && id != 0 {
+    return strconv.FormatInt(id, 10)
+  }
+  return msg.Id
+}
+
+func (msg *Message) mediaRequest(ctx context.Context) (*http.Request, error) {
+  bot := msg.bot
+  sess := bot.sessionCopy()
+  var addr *url.URL
+  q := url.Values{}
+  switch msg.Type {
+  case MsgImage:
+    addr, _ = url.Parse(sess.BaseUrl + getMsgImgUrlPath)
+    q.Set("MsgID", msg.msgId())
+    q.Set("skey", sess.SKey)
+  case MsgVoice:
+    addr, _ = url.Parse(sess.BaseUrl + getVoiceUrlPath)
+    q.Set("msgid", msg.msgId())
+    q.Set("skey", sess.SKey)
+  case MsgVideo, MsgVideoCall:
+    addr, _ = url.Parse(sess.BaseUrl + getVideoUrlPath)
+    q.Set("msgid", msg.msgId())
+    q.Set("skey", sess.SKey)
+  default:
+    mediaId, _ := jsonparser.GetString(msg.raw, "MediaId")
+    name, _ := jsonparser.GetString(msg.raw, "FileName")
+    encryName, _ := jsonparser.GetString(msg.raw, "EncryFileName")
+    addr, _ = url.Parse(fmt.Sprintf("https://%s/cgi-bin/mmwebwx-bin%s", sess.FileHost, getMediaUrlPath))
+    q.Set("sender", msg.FromUserName)
+    q.Set("mediaid", mediaId)
+    q.Set("encryfilename", encryName)
+    q.Set("filename", name)
+    q.Set("fromuser", strconv.FormatInt(sess.Uin, 10))
+    q.Set("pass_ticket", sess.PassTicket)
+    q.Set("webwx_data_ticket", bot.req.cookie("webwx_data_ticket"))
+  }
+  addr.RawQuery = q.Encode()
+  req, e := http.NewRequestWithContext(ctx, "GET", addr.String(), nil)
+  if e != nil {
+    return nil, e
+  }
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", bot.userAgent)
+  if msg.Type == MsgVideo || msg.Type == MsgVideoCall {
+    // 视频必须带Range，否则返回空
+    req.Header.Set("Range", "bytes=0-")
+  }
+  return req, nil
+}
+
+// 开启了MediaPolicy.AutoSave时，在回调OnMessage之前保存，
+// 在dispatcher的goroutine中执行，最多等待AutoSaveTimeout，避免大文件阻塞同一会话后面的事件
+func (bot *Bot) autoSaveMedia(msg *Message) {
+  p := bot.mediaPolicy
+  if !p.AutoSave || !msg.HasMedia() {
+    return
+  }
+  timeout := p.AutoSaveTimeout
+  if timeout <= 0 {
+    timeout = defaultAutoSaveTimeout
+  }
+  ctx, cancel := context.WithTimeout(bot.ctx, timeout)
+  defer cancel()
+  dst, e := msg.saveMedia(ctx)
+  if e != nil {
+    bot.logger.Printf("wxweb: save media of message %s failed: %v", msg.Id, e)
+    return
+  }
+  msg.SetAttr(attrMediaPath, dst)
+}
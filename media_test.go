@@ -0,0 +1,95 @@
+package wxweb
+
+import (
+  "bytes"
+  "net/http"
+  "os"
+  "testing"
+  "time"
+)
+
+func TestMessageHasMedia(t *testing.T) {
+  tests := []struct {
+    name string
+    typ  int
+    raw  string
+    want bool
+  }{
+    {"text", MsgText, `{}`, false},
+    {"image", MsgImage, `{}`, true},
+    {"voice", MsgVoice, `{}`, true},
+    {"video", MsgVideo, `{}`, true},
+    {"video call", MsgVideoCall, `{}`, true},
+    {"file", MsgLink, `{"AppMsgType":6,"MediaId":"m"}`, true},
+    {"file without media id", MsgLink, `{"AppMsgType":6}`, false},
+    {"link", MsgLink, `{"AppMsgType":5,"MediaId":"m"}`, false},
+  }
+  for _, tt := range tests {
+    msg := &Message{Type: tt.typ, raw: []byte(tt.raw)}
+    if got := msg.HasMedia(); got != tt.want {
+      t.Errorf("%s: HasMedia() = %v, want %v", tt.name, got, tt.want)
+    }
+  }
+}
+
+func TestMessageDownload(t *testing.T) {
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "webwxgetmsgimg": "image",
+    "webwxgetvideo":  "video",
+  }))
+  tests := []struct {
+    name    string
+    typ     int
+    maxSize int64
+    want    string
+    wantErr error
+  }{
+    {"image", MsgImage, 0, "image", nil},
+    {"video call", MsgVideoCall, 0, "video", nil},
+    {"too large", MsgImage, 3, "", ErrMediaTooLarge},
+    {"no media", MsgText, 0, "", ErrNoMedia},
+  }
+  for _, tt := range tests {
+    bot.SetMediaPolicy(MediaPolicy{MaxSize: tt.maxSize})
+    msg := testMessage(bot, "1", "@a")
+    msg.Type = tt.typ
+    var buf bytes.Buffer
+    _, e := msg.Download(&buf)
+    if e != tt.wantErr {
+      t.Errorf("%s: Download() error = %v, want %v", tt.name, e, tt.wantErr)
+      continue
+    }
+    if e == nil && buf.String() != tt.want {
+      t.Errorf("%s: Download() = %q, want %q", tt.name, buf.String(), tt.want)
+    }
+  }
+}
+
+// 自动保存卡住时不能一直阻塞回调
+func TestAutoSaveMediaTimeout(t *testing.T) {
+  rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+    <-req.Context().Done()
+    return nil, req.Context().Err()
+  })
+  bot := newTestBot(t, rt, WithMediaPolicy(MediaPolicy{AutoSave: true, AutoSaveTimeout: time.Millisecond * 20}))
+  bot.updatePaths()
+  defer bot.Stop()
+  msg := testMessage(bot, "1", "@a")
+  msg.Type = MsgImage
+  done := make(chan struct{})
+  go func() {
+    defer close(done)
+    bot.autoSaveMedia(msg)
+  }()
+  select {
+  case <-done:
+  case <-time.After(time.Second * 5):
+    t.Fatal("autoSaveMedia did not time out")
+  }
+  if p := msg.MediaPath(); p != "" {
+    t.Errorf("MediaPath() = %q, want empty", p)
+  }
+  if _, e := os.Stat(bot.GetAttrString(attrImageDir, "") + "/1.jpg"); !os.IsNotExist(e) {
+    t.Errorf("partial file not removed: %v", e)
+  }
+}
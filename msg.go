@@ -141,23 +141,25 @@ func (msg *Message) Raw() []byte {
 }
 
 func (msg *Message) GetFromContact() *Contact {
-  if msg.bot.contacts == nil {
+  contacts := msg.bot.Contacts()
+  if contacts == nil {
     return nil
   }
-  if msg.FromUserName == msg.bot.session.UserName {
-    return msg.bot.self
+  if msg.FromUserName == msg.bot.sessionCopy().UserName {
+    return msg.bot.Self()
   }
-  return msg.bot.contacts.Get(msg.FromUserName)
+  return contacts.Get(msg.FromUserName)
 }
 
 func (msg *Message) GetToContact() *Contact {
-  if msg.bot.contacts == nil {
+  contacts := msg.bot.Contacts()
+  if contacts == nil {
     return nil
   }
-  if msg.ToUserName == msg.bot.session.UserName {
-    return msg.bot.self
+  if msg.ToUserName == msg.bot.sessionCopy().UserName {
+    return msg.bot.Self()
   }
-  return msg.bot.contacts.Get(msg.ToUserName)
+  return contacts.Get(msg.ToUserName)
 }
 
 func (msg *Message) ReplyText(text string) error {
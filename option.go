@@ -0,0 +1,209 @@
+package wxweb
+
+import (
+  "net/http"
+  "net/http/cookiejar"
+  "path"
+  "time"
+
+  "golang.org/x/net/publicsuffix"
+)
+
+const (
+  defaultTimeout   = time.Minute * 2
+  defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/71.0.3578.98 Safari/537.36"
+  defaultAppId     = "wx782c26e4c19acffb"
+  defaultLang      = "zh_CN"
+  defaultRootDir   = "wxweb"
+
+  defaultStopTimeout = time.Second * 10
+)
+
+type Logger interface {
+  Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// 创建Bot时的配置，如：
+// New(WithUserAgent(ua), WithRootDir("/var/lib/wxweb"))
+type Option func(*Bot)
+
+// 使用自定义的http.Client（会复制一份，不会修改传入的Client），
+// 如果Client没有设置Jar，会创建一个新的
+func WithHTTPClient(client *http.Client) Option {
+  return func(bot *Bot) {
+    if client == nil {
+      return
+    }
+    c := *client
+    if c.Jar == nil {
+      c.Jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+    }
+    c.Jar = newCookieJar(c.Jar)
+    bot.client = &c
+  }
+}
+
+// 设置http.Client的Transport（如代理），
+// 如果同时使用了WithHTTPClient，需要放在它后面
+func WithTransport(transport http.RoundTripper) Option {
+  return func(bot *Bot) {
+    bot.client.Transport = transport
+  }
+}
+
+// 所有请求的默认超时时间，默认2分钟
+func WithTimeout(timeout time.Duration) Option {
+  return func(bot *Bot) {
+    bot.client.Timeout = timeout
+  }
+}
+
+// 单独设置某个请求的超时时间，
+// endpoint是请求路径的最后一段，如：synccheck、webwxsync、webwxuploadmedia，
+// synccheck是长轮询（服务器约25秒返回），超时时间不能太短
+func WithEndpointTimeout(endpoint string, timeout time.Duration) Option {
+  return func(bot *Bot) {
+    bot.timeouts[endpoint] = timeout
+  }
+}
+
+func WithUserAgent(userAgent string) Option {
+  return func(bot *Bot) {
+    if userAgent != "" {
+      bot.userAgent = userAgent
+    }
+  }
+}
+
+func WithAppId(appId string) Option {
+  return func(bot *Bot) {
+    if appId != "" {
+      bot.appId = appId
+    }
+  }
+}
+
+// 语言，默认zh_CN
+func WithLang(lang string) Option {
+  return func(bot *Bot) {
+    if lang != "" {
+      bot.lang = lang
+    }
+  }
+}
+
+// 存放文件（图片、语音、视频、头像和dump等）的根目录，默认是当前目录下的wxweb
+func WithRootDir(dir string) Option {
+  return func(bot *Bot) {
+    if dir != "" {
+      bot.rootDir = dir
+    }
+  }
+}
+
+// 获取当前时间（包括时区），默认是上海时间，
+// 用于StartTime/StopTime、按天分目录等
+func WithClock(clock func() time.Time) Option {
+  return func(bot *Bot) {
+    if clock != nil {
+      bot.clock = clock
+    }
+  }
+}
+
+func WithLogger(logger Logger) Option {
+  return func(bot *Bot) {
+    if logger != nil {
+      bot.logger = logger
+    }
+  }
+}
+
+func WithSessionStore(store SessionStore) Option {
+  return func(bot *Bot) {
+    bot.SetSessionStore(store)
+  }
+}
+
+func WithQRPolicy(policy QRPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetQRPolicy(policy)
+  }
+}
+
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetReconnectPolicy(policy)
+  }
+}
+
+func WithBackoffPolicy(policy BackoffPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetBackoffPolicy(policy)
+  }
+}
+
+func WithWatchdogTimeout(timeout time.Duration) Option {
+  return func(bot *Bot) {
+    bot.SetWatchdogTimeout(timeout)
+  }
+}
+
+// Stop等待回调返回的最长时间，默认10秒
+func WithStopTimeout(timeout time.Duration) Option {
+  return func(bot *Bot) {
+    if timeout > 0 {
+      bot.stopTimeout = timeout
+    }
+  }
+}
+
+// 加入指定的Manager（默认加入DefaultManager）
+func WithManager(m *Manager) Option {
+  return func(bot *Bot) {
+    bot.manager = m
+  }
+}
+
+func WithDispatchPolicy(policy DispatchPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetDispatchPolicy(policy)
+  }
+}
+
+func WithDedupPolicy(policy DedupPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetDedupPolicy(policy)
+  }
+}
+
+func WithEventsPolicy(policy EventsPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetEventsPolicy(policy)
+  }
+}
+
+func WithMediaPolicy(policy MediaPolicy) Option {
+  return func(bot *Bot) {
+    bot.SetMediaPolicy(policy)
+  }
+}
+
+func (bot *Bot) now() time.Time {
+  return bot.clock()
+}
+
+// 发送请求，如果该请求单独设置了超时时间，使用相同配置（共享Jar和Transport）但超时时间不同的Client
+func (bot *Bot) httpDo(req *http.Request) (*http.Response, error) {
+  client := bot.client
+  if timeout, ok := bot.timeouts[path.Base(req.URL.Path)]; ok {
+    c := *client
+    c.Timeout = timeout
+    client = &c
+  }
+  return client.Do(req)
+}
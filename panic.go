@@ -0,0 +1,61 @@
+package wxweb
+
+import (
+  "fmt"
+  "runtime/debug"
+)
+
+// 错误通知（可选），
+// Handler实现了该接口时，回调中发生panic会通过OnError通知（参数类型为*PanicError），
+// 未实现时只记录日志，synccheck/sync都会继续
+type ErrorHandler interface {
+  OnError(error)
+}
+
+// 回调中发生的panic
+type PanicError struct {
+  // 发生panic的回调，如OnMessage
+  Callback string
+
+  // recover()返回的值和panic时的调用栈
+  Value interface{}
+  Stack []byte
+
+  // 正在处理的消息或联系人（其中一个不为nil）
+  Message *Message
+  Contact *Contact
+}
+
+func (e *PanicError) Error() string {
+  return fmt.Sprintf("panic in %s: %v", e.Callback, e.Value)
+}
+
+// 引起panic的原始数据（Message.Raw()或Contact.Raw()）
+func (e *PanicError) Raw() []byte {
+  if e.Message != nil {
+    return e.Message.Raw()
+  }
+  if e.Contact != nil {
+    return e.Contact.Raw()
+  }
+  return nil
+}
+
+// 在回调前defer调用，
+// 不能在其他函数中调用recover，所以这里直接recover
+func (bot *Bot) recoverCallback(callback string, msg *Message, c *Contact) {
+  v := recover()
+  if v == nil {
+    return
+  }
+  e := &PanicError{Callback: callback, Value: v, Stack: debug.Stack(), Message: msg, Contact: c}
+  bot.logger.Printf("wxweb: %v\n%s", e, e.Stack)
+  if h, ok := bot.handler.(ErrorHandler); ok {
+    defer func() {
+      if v := recover(); v != nil {
+        bot.logger.Printf("wxweb: panic in OnError: %v", v)
+      }
+    }()
+    h.OnError(e)
+  }
+}
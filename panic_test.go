@@ -0,0 +1,58 @@
+package wxweb
+
+import (
+  "testing"
+)
+
+type panicHandler struct {
+  *testHandler
+}
+
+func (h *panicHandler) OnMessage(*Message, int) {
+  panic("message")
+}
+
+func (h *panicHandler) OnContactEvent(*ContactEvent) {
+  panic("contact")
+}
+
+func (h *panicHandler) OnGroupEvent(*GroupEvent) {
+  panic("group")
+}
+
+func TestDeliverRecoversPanic(t *testing.T) {
+  bot := newTestBot(t, failingTransport())
+  msg := testMessage(bot, "1", "@a")
+  c := buildContact([]byte(`{"UserName":"@a","NickName":"a"}`), bot)
+  tests := []struct {
+    name     string
+    e        *event
+    callback string
+    value    string
+    wantRaw  bool
+  }{
+    {"message", &event{kind: eventMessage, msg: msg}, "OnMessage", "message", true},
+    {"contact", &event{kind: eventContact, contact: &ContactEvent{Kind: ContactAdded, New: c}}, "OnContact", "contact", true},
+    {"group", &event{kind: eventGroup, group: &GroupEvent{Kind: GroupRenamed, GroupUserName: "@@g"}}, "OnGroupEvent", "group", false},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      h := &panicHandler{&testHandler{}}
+      bot.handler = h
+      bot.deliver(tt.e)
+      if len(h.errs) != 1 {
+        t.Fatalf("got %d errors, want 1", len(h.errs))
+      }
+      pe, ok := h.errs[0].(*PanicError)
+      if !ok {
+        t.Fatalf("error = %T, want *PanicError", h.errs[0])
+      }
+      if pe.Callback != tt.callback || pe.Value != tt.value || len(pe.Stack) == 0 {
+        t.Errorf("PanicError = %+v", pe)
+      }
+      if (len(pe.Raw()) > 0) != tt.wantRaw {
+        t.Errorf("Raw() = %q", pe.Raw())
+      }
+    })
+  }
+}
@@ -0,0 +1,71 @@
+package wxweb
+
+import (
+  "bufio"
+  "io"
+
+  "github.com/skip2/go-qrcode"
+)
+
+// 二维码的内容，手机扫描的就是这个地址
+const qrContentUrl = "https://login.weixin.qq.com/l/"
+
+func (bot *Bot) qrContent() (string, error) {
+  uuid := bot.sessionCopy().UUID
+  if uuid == "" {
+    return "", ErrInvalidState
+  }
+  return qrContentUrl + uuid, nil
+}
+
+// 生成二维码图片（PNG），
+// size是图片宽高（像素），小于等于0时使用默认大小256
+func (bot *Bot) QRCodePNG(size int) ([]byte, error) {
+  content, e := bot.qrContent()
+  if e != nil {
+    return nil, e
+  }
+  if size <= 0 {
+    size = 256
+  }
+  return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// 在终端输出二维码，
+// 每个字符用上下半块表示两行，并用ANSI颜色固定为黑底白块，
+// 不管终端是深色还是浅色背景都能扫描
+func (bot *Bot) WriteQRCodeTerminal(w io.Writer) error {
+  content, e := bot.qrContent()
+  if e != nil {
+    return e
+  }
+  qr, e := qrcode.New(content, qrcode.Low)
+  if e != nil {
+    return e
+  }
+  // true表示黑色模块，输出时黑色模块不填充（即背景色），白色模块填充（即前景色）
+  bitmap := qr.Bitmap()
+  bw := bufio.NewWriter(w)
+  for y := 0; y < len(bitmap); y += 2 {
+    bw.WriteString("\x1b[97;40m")
+    for x := 0; x < len(bitmap[y]); x++ {
+      top := !bitmap[y][x]
+      bottom := false
+      if y+1 < len(bitmap) {
+        bottom = !bitmap[y+1][x]
+      }
+      switch {
+      case top && bottom:
+        bw.WriteString("█")
+      case top:
+        bw.WriteString("▀")
+      case bottom:
+        bw.WriteString("▄")
+      default:
+        bw.WriteString(" ")
+      }
+    }
+    bw.WriteString("\x1b[0m\n")
+  }
+  return bw.Flush()
+}
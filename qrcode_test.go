@@ -0,0 +1,35 @@
+package wxweb
+
+import (
+  "testing"
+  "time"
+
+  "github.com/kwf2030/commons/pipeline"
+)
+
+// 获取二维码的同时在其他goroutine中生成二维码图片，需要用go test -race运行
+func TestQRCodeDuringSignIn(t *testing.T) {
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "jslogin": `window.QRLogin.code = 200; window.QRLogin.uuid = "uuid-a";`,
+  }))
+  bot.handler = &testHandler{}
+  done := make(chan string)
+  go func() {
+    for {
+      if _, e := bot.QRCodePNG(0); e == nil {
+        content, _ := bot.qrContent()
+        done <- content
+        return
+      }
+    }
+  }()
+  pipeline.New().AddLast(StageQR, &qrReq{bot}).Fire(nil)
+  select {
+  case content := <-done:
+    if want := qrContentUrl + "uuid-a"; content != want {
+      t.Errorf("qrContent() = %q, want %q", content, want)
+    }
+  case <-time.After(time.Second * 5):
+    t.Fatalf("uuid not set, Err() = %v", bot.Err())
+  }
+}
@@ -0,0 +1,148 @@
+package wxweb
+
+import (
+  "time"
+
+  "github.com/kwf2030/commons/base"
+)
+
+// synccheck返回非0的retcode时的重连策略，
+// 每次重连先用当前的凭据重新init，失败再尝试push登录（需要在手机上确认），
+// 都失败才会下线（回调OnSignOut）
+type ReconnectPolicy struct {
+  // 最多重连几次，0表示不重连
+  MaxAttempts int
+
+  // 这些retcode直接下线，不重连
+  FatalCodes []int
+
+  // init失败时是否尝试push登录
+  PushLogin bool
+
+  // push登录等待在手机上确认的时间，默认2分钟
+  PushTimeout time.Duration
+}
+
+var defaultReconnectPolicy = ReconnectPolicy{
+  MaxAttempts: 3,
+  // 1101通常是在手机上主动退出了Web微信
+  FatalCodes:  []int{1101},
+  PushLogin:   true,
+  PushTimeout: time.Minute * 2,
+}
+
+// 重连通知（可选），
+// Handler实现了该接口时，每次重连和重连成功都会回调
+type ReconnectHandler interface {
+  // 开始重连，参数为synccheck返回的retcode（watchdog触发时为0）和第几次重连（从1开始）
+  OnReconnecting(int, int)
+
+  // 重连成功
+  OnReconnected()
+}
+
+func (bot *Bot) SetReconnectPolicy(policy ReconnectPolicy) {
+  if policy.PushTimeout <= 0 {
+    policy.PushTimeout = defaultReconnectPolicy.PushTimeout
+  }
+  // 复制一份，修改传入的（或默认的）切片不影响其他Bot
+  policy.FatalCodes = append([]int(nil), policy.FatalCodes...)
+  bot.reconnectPolicy = policy
+}
+
+func (p *ReconnectPolicy) isFatal(code int) bool {
+  for _, v := range p.FatalCodes {
+    if v == code {
+      return true
+    }
+  }
+  return false
+}
+
+// 返回true表示重连成功，可以继续synccheck，
+// code为0表示是watchdog触发的重连
+func (bot *Bot) reconnect(code int) bool {
+  p := bot.reconnectPolicy
+  if p.isFatal(code) {
+    return false
+  }
+  h, _ := bot.handler.(ReconnectHandler)
+  bot.setReconnecting(true)
+  defer bot.setReconnecting(false)
+  for i := 1; i <= p.MaxAttempts; i++ {
+    if bot.ctx.Err() != nil {
+      return false
+    }
+    if h != nil {
+      h.OnReconnecting(code, i)
+    }
+    e := (&initReq{bot}).run()
+    if e != nil {
+      bot.logger.Printf("wxweb: reconnect(%d) init failed: %v", i, e)
+      if p.PushLogin {
+        e = bot.reconnectPush(p.PushTimeout)
+        if e != nil {
+          bot.logger.Printf("wxweb: reconnect(%d) push login failed: %v", i, e)
+        }
+      }
+    }
+    if e == nil {
+      (&notifyReq{bot}).do()
+      if e = bot.saveSession(); e != nil {
+        bot.logger.Printf("wxweb: save session failed: %v", e)
+      }
+      if h != nil {
+        h.OnReconnected()
+      }
+      return true
+    }
+    if !bot.sleep() {
+      return false
+    }
+  }
+  return false
+}
+
+func (bot *Bot) reconnectPush(timeout time.Duration) error {
+  uuid, e := bot.pushLogin()
+  if e != nil {
+    return e
+  }
+  bot.mu.Lock()
+  bot.session.UUID = uuid
+  bot.mu.Unlock()
+  redirectUrl := bot.waitConfirm(timeout)
+  if redirectUrl == "" {
+    if e = bot.ctx.Err(); e != nil {
+      return e
+    }
+    return base.ErrTimeout
+  }
+  bot.mu.Lock()
+  bot.session.RedirectUrl = redirectUrl
+  bot.mu.Unlock()
+  if e = (&redirectReq{bot}).run(); e != nil {
+    return e
+  }
+  return (&initReq{bot}).run()
+}
+
+// 等待在手机上确认push登录，
+// 与scanReq.check不同，这里不修改状态也不回调ScanHandler
+func (bot *Bot) waitConfirm(timeout time.Duration) string {
+  r := &scanReq{bot}
+  deadline := time.Now().Add(timeout)
+  for time.Now().Before(deadline) && bot.ctx.Err() == nil {
+    resp, e := r.do()
+    if e == nil {
+      switch resp.code {
+      case 200:
+        return resp.redirectUrl
+      case 400:
+        return ""
+      }
+    }
+    bot.sleep()
+  }
+  return ""
+}
@@ -0,0 +1,41 @@
+package wxweb
+
+import (
+  "testing"
+)
+
+func TestReconnectPolicyIsFatal(t *testing.T) {
+  tests := []struct {
+    name   string
+    policy ReconnectPolicy
+    code   int
+    want   bool
+  }{
+    {"default 1101", defaultReconnectPolicy, 1101, true},
+    {"default 1100", defaultReconnectPolicy, 1100, false},
+    {"watchdog", defaultReconnectPolicy, 0, false},
+    {"custom", ReconnectPolicy{FatalCodes: []int{1100, 1102}}, 1102, true},
+    {"none", ReconnectPolicy{}, 1101, false},
+  }
+  for _, tt := range tests {
+    if got := tt.policy.isFatal(tt.code); got != tt.want {
+      t.Errorf("%s: isFatal(%d) = %v, want %v", tt.name, tt.code, got, tt.want)
+    }
+  }
+}
+
+// 修改一个Bot的FatalCodes不能影响其他Bot和默认值
+func TestReconnectPolicyFatalCodesCopied(t *testing.T) {
+  a := newTestBot(t, failingTransport())
+  b := newTestBot(t, failingTransport())
+  a.reconnectPolicy.FatalCodes[0] = 1100
+  if b.reconnectPolicy.FatalCodes[0] != 1101 || defaultReconnectPolicy.FatalCodes[0] != 1101 {
+    t.Errorf("FatalCodes shared between bots: %v, default %v", b.reconnectPolicy.FatalCodes, defaultReconnectPolicy.FatalCodes)
+  }
+  codes := []int{1101}
+  c := newTestBot(t, failingTransport(), WithReconnectPolicy(ReconnectPolicy{FatalCodes: codes}))
+  codes[0] = 1100
+  if !c.reconnectPolicy.isFatal(1101) {
+    t.Error("FatalCodes not copied in SetReconnectPolicy")
+  }
+}
@@ -0,0 +1,73 @@
+package wxweb
+
+import (
+  "context"
+
+  "github.com/kwf2030/commons/base"
+  "github.com/kwf2030/commons/pipeline"
+)
+
+// 自定义的登录阶段，可以插入到内置阶段（StageInit、StageContacts、StageSync等）的前面或后面，
+// Run返回error时终止登录，OnSignIn收到*SignInError（Stage为该阶段的名字），
+// 如果插入在StageSync之后（此时已登录成功），返回error会使Bot下线（Err返回该error），
+// 使用保存的凭据恢复登录时，StageQR、StageScan和StageRedirect不会执行，但插入在它们前后的阶段仍会执行
+type Stage interface {
+  Run(context.Context, *Bot) error
+}
+
+type StageFunc func(context.Context, *Bot) error
+
+func (f StageFunc) Run(ctx context.Context, bot *Bot) error {
+  return f(ctx, bot)
+}
+
+// 在mark阶段之前插入name阶段，必须在Start之前调用
+func (bot *Bot) AddStageBefore(mark, name string, stage Stage) error {
+  if e := bot.checkStage(mark, name, stage); e != nil {
+    return e
+  }
+  bot.signInPipeline.AddBefore(mark, name, &stageReq{bot, name, stage})
+  return nil
+}
+
+// 在mark阶段之后插入name阶段，必须在Start之前调用
+func (bot *Bot) AddStageAfter(mark, name string, stage Stage) error {
+  if e := bot.checkStage(mark, name, stage); e != nil {
+    return e
+  }
+  bot.signInPipeline.AddAfter(mark, name, &stageReq{bot, name, stage})
+  return nil
+}
+
+func (bot *Bot) checkStage(mark, name string, stage Stage) error {
+  if mark == "" || name == "" || stage == nil {
+    return base.ErrInvalidArgument
+  }
+  if bot.started {
+    return ErrInvalidState
+  }
+  if bot.signInPipeline.Get(mark) == nil || bot.signInPipeline.Get(name) != nil {
+    return base.ErrInvalidArgument
+  }
+  return nil
+}
+
+type stageReq struct {
+  *Bot
+  name  string
+  stage Stage
+}
+
+func (r *stageReq) Handle(ctx *pipeline.HandlerContext, val interface{}) {
+  e := r.stage.Run(r.ctx, r.Bot)
+  if e == nil {
+    ctx.Fire(val)
+    return
+  }
+  if r.State() == StateRunning {
+    r.logger.Printf("wxweb: stage %s failed: %v", r.name, e)
+    r.shutdown(e)
+    return
+  }
+  r.signInFailed(r.name, e)
+}
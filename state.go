@@ -0,0 +1,66 @@
+package wxweb
+
+import (
+  "sync/atomic"
+)
+
+// 状态变化通知（可选），
+// Handler实现了该接口时，每次状态变化都会回调，参数为旧状态和新状态
+type StateHandler interface {
+  OnStateChange(int, int)
+}
+
+// 允许的状态变化，
+// StateStop是最终状态，不能再变为其他状态
+var stateTransitions = map[int][]int{
+  stateUnknown:     {StateScan, StateScanTimeout, StateConfirm, StateRunning, StateStop},
+  StateScan:        {StateConfirm, StateScanTimeout, StateStop},
+  StateScanTimeout: {StateScan, StateConfirm, StateStop},
+  StateConfirm:     {StateRunning, StateStop},
+  StateRunning:     {StateStop},
+}
+
+func validTransition(from, to int) bool {
+  for _, v := range stateTransitions[from] {
+    if v == to {
+      return true
+    }
+  }
+  return false
+}
+
+func (bot *Bot) State() int {
+  return int(atomic.LoadInt32(&bot.state))
+}
+
+// 切换状态并回调OnStateChange，
+// 返回false表示状态没有变化（已经是该状态或不允许变为该状态）
+func (bot *Bot) setState(state int) bool {
+  old, ok := bot.transition(state)
+  if ok {
+    bot.notifyState(old, state)
+  }
+  return ok
+}
+
+func (bot *Bot) transition(state int) (int, bool) {
+  for {
+    old := atomic.LoadInt32(&bot.state)
+    if int(old) == state {
+      return int(old), false
+    }
+    if !validTransition(int(old), state) {
+      bot.logger.Printf("wxweb: invalid state transition %d -> %d", old, state)
+      return int(old), false
+    }
+    if atomic.CompareAndSwapInt32(&bot.state, old, int32(state)) {
+      return int(old), true
+    }
+  }
+}
+
+func (bot *Bot) notifyState(old, state int) {
+  if h, ok := bot.handler.(StateHandler); ok {
+    h.OnStateChange(old, state)
+  }
+}
@@ -0,0 +1,84 @@
+package wxweb
+
+import (
+  "reflect"
+  "sync"
+  "testing"
+)
+
+func TestValidTransition(t *testing.T) {
+  tests := []struct {
+    from, to int
+    want     bool
+  }{
+    {stateUnknown, StateScan, true},
+    {stateUnknown, StateRunning, true},
+    {StateScan, StateConfirm, true},
+    {StateScan, StateRunning, false},
+    {StateScanTimeout, StateScan, true},
+    {StateConfirm, StateScan, false},
+    {StateConfirm, StateRunning, true},
+    {StateRunning, StateScan, false},
+    {StateRunning, StateStop, true},
+    {StateStop, StateRunning, false},
+    {StateStop, stateUnknown, false},
+  }
+  for _, tt := range tests {
+    if got := validTransition(tt.from, tt.to); got != tt.want {
+      t.Errorf("validTransition(%d, %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+    }
+  }
+}
+
+func TestSetState(t *testing.T) {
+  tests := []struct {
+    name       string
+    states     []int
+    wantOK     []bool
+    wantNotify [][2]int
+  }{
+    {"sign in", []int{StateScan, StateConfirm, StateRunning, StateStop}, []bool{true, true, true, true},
+      [][2]int{{stateUnknown, StateScan}, {StateScan, StateConfirm}, {StateConfirm, StateRunning}, {StateRunning, StateStop}}},
+    {"same state", []int{StateScan, StateScan}, []bool{true, false}, [][2]int{{stateUnknown, StateScan}}},
+    {"invalid", []int{StateConfirm, StateScan}, []bool{true, false}, [][2]int{{stateUnknown, StateConfirm}}},
+    {"stop is final", []int{StateStop, StateRunning}, []bool{true, false}, [][2]int{{stateUnknown, StateStop}}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      bot := newTestBot(t, failingTransport())
+      h := &testHandler{}
+      bot.handler = h
+      for i, v := range tt.states {
+        if ok := bot.setState(v); ok != tt.wantOK[i] {
+          t.Errorf("setState(%d) = %v, want %v", v, ok, tt.wantOK[i])
+        }
+      }
+      if !reflect.DeepEqual(h.states, tt.wantNotify) {
+        t.Errorf("OnStateChange = %v, want %v", h.states, tt.wantNotify)
+      }
+    })
+  }
+}
+
+// 并发切换到同一个状态时只有一个成功
+func TestTransitionConcurrent(t *testing.T) {
+  bot := newTestBot(t, failingTransport())
+  var wg sync.WaitGroup
+  var mu sync.Mutex
+  n := 0
+  for i := 0; i < 16; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      if _, ok := bot.transition(StateRunning); ok {
+        mu.Lock()
+        n++
+        mu.Unlock()
+      }
+    }()
+  }
+  wg.Wait()
+  if n != 1 {
+    t.Errorf("%d transitions succeeded, want 1", n)
+  }
+}
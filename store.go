@@ -0,0 +1,291 @@
+package wxweb
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// 保存登录凭据，重启后可以直接恢复登录而不需要重新扫码
+type SessionStore interface {
+  // 读取上次保存的凭据，没有凭据时返回(nil, nil)
+  Load() (*SessionData, error)
+
+  // 登录成功（获取联系人之后）、重连成功和SyncKey变化之后保存凭据
+  Save(*SessionData) error
+
+  // 删除凭据（已退出登录，凭据不能再使用）
+  Remove() error
+}
+
+type SessionData struct {
+  Host          string
+  SyncCheckHost string
+  Referer       string
+  BaseUrl       string
+
+  SKey       string
+  Sid        string
+  Uin        int64
+  PassTicket string
+  DeviceId   string
+
+  // 格式同synccheck的synckey参数，如：1_123|2_456，
+  // SyncCheckKey为空时与SyncKey相同
+  SyncKey      string
+  SyncCheckKey string
+  UserName     string
+
+  // 地址->Cookie，包括Domain、Path和Expires（恢复时按地址设置）
+  Cookies map[string][]*http.Cookie
+
+  SaveTime time.Time
+}
+
+type FileSessionStore struct {
+  path string
+}
+
+func NewFileSessionStore(path string) *FileSessionStore {
+  return &FileSessionStore{path: path}
+}
+
+func (s *FileSessionStore) Load() (*SessionData, error) {
+  data, e := ioutil.ReadFile(s.path)
+  if e != nil {
+    if os.IsNotExist(e) {
+      return nil, nil
+    }
+    return nil, e
+  }
+  ret := &SessionData{}
+  e = json.Unmarshal(data, ret)
+  if e != nil {
+    return nil, e
+  }
+  return ret, nil
+}
+
+func (s *FileSessionStore) Save(sd *SessionData) error {
+  data, e := json.Marshal(sd)
+  if e != nil {
+    return e
+  }
+  e = os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+  if e != nil {
+    return e
+  }
+  // 先写临时文件再重命名，避免写到一半时进程退出导致文件损坏
+  tmp := s.path + ".tmp"
+  e = ioutil.WriteFile(tmp, data, 0600)
+  if e != nil {
+    return e
+  }
+  return os.Rename(tmp, s.path)
+}
+
+func (s *FileSessionStore) Remove() error {
+  e := os.Remove(s.path)
+  if e != nil && !os.IsNotExist(e) {
+    return e
+  }
+  return nil
+}
+
+func (bot *Bot) SetSessionStore(store SessionStore) {
+  bot.store = store
+}
+
+// 记录服务器设置的Cookie，
+// cookiejar.Jar.Cookies只返回Name和Value（没有Domain和Expires），保存凭据时使用这里记录的
+type cookieJar struct {
+  http.CookieJar
+
+  mu      sync.Mutex
+  cookies map[string]*http.Cookie
+}
+
+func newCookieJar(jar http.CookieJar) *cookieJar {
+  if j, ok := jar.(*cookieJar); ok {
+    return j
+  }
+  return &cookieJar{CookieJar: jar, cookies: make(map[string]*http.Cookie, 16)}
+}
+
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+  j.CookieJar.SetCookies(u, cookies)
+  now := time.Now()
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  for _, v := range cookies {
+    c := *v
+    if c.Domain == "" {
+      c.Domain = u.Hostname()
+    }
+    c.Domain = strings.TrimPrefix(c.Domain, ".")
+    if c.Path == "" || c.Path[0] != '/' {
+      c.Path = "/"
+    }
+    // MaxAge是相对时间，保存之后就不对了，换算成Expires
+    if c.MaxAge > 0 {
+      c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+      c.MaxAge = 0
+    }
+    k := c.Domain + ";" + c.Path + ";" + c.Name
+    if c.MaxAge < 0 || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+      delete(j.cookies, k)
+      continue
+    }
+    c.Raw = ""
+    c.Unparsed = nil
+    j.cookies[k] = &c
+  }
+}
+
+// 没有过期的Cookie，按地址（https://Domain）分组
+func (j *cookieJar) all() map[string][]*http.Cookie {
+  now := time.Now()
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  ret := make(map[string][]*http.Cookie, 4)
+  for _, v := range j.cookies {
+    if !v.Expires.IsZero() && !v.Expires.After(now) {
+      continue
+    }
+    c := *v
+    addr := "https://" + c.Domain
+    ret[addr] = append(ret[addr], &c)
+  }
+  return ret
+}
+
+// 需要保存Cookie的地址（Jar不是cookieJar时使用）
+func (s *session) cookieUrls() []string {
+  arr := []string{
+    s.BaseUrl,
+    "https://" + s.SyncCheckHost,
+    "https://" + s.FileHost,
+    "https://login.weixin.qq.com",
+  }
+  return arr
+}
+
+func (bot *Bot) saveSession() error {
+  if bot.store == nil {
+    return nil
+  }
+  sd := &SessionData{
+    Host:          bot.session.Host,
+    SyncCheckHost: bot.session.SyncCheckHost,
+    Referer:       bot.session.Referer,
+    BaseUrl:       bot.session.BaseUrl,
+    SKey:          bot.session.SKey,
+    Sid:           bot.session.Sid,
+    Uin:           bot.session.Uin,
+    PassTicket:    bot.session.PassTicket,
+    DeviceId:      bot.session.BaseReq.DeviceId,
+    SyncKey:       bot.session.SyncKey.expand(),
+    SyncCheckKey:  bot.session.SyncCheckKey.expand(),
+    UserName:      bot.session.UserName,
+    SaveTime:      time.Now(),
+  }
+  if jar, ok := bot.client.Jar.(*cookieJar); ok {
+    sd.Cookies = jar.all()
+    return bot.store.Save(sd)
+  }
+  sd.Cookies = make(map[string][]*http.Cookie, 4)
+  for _, v := range bot.session.cookieUrls() {
+    addr, e := url.Parse(v)
+    if e != nil {
+      continue
+    }
+    if arr := bot.client.Jar.Cookies(addr); len(arr) > 0 {
+      sd.Cookies[v] = arr
+    }
+  }
+  return bot.store.Save(sd)
+}
+
+// 删除保存的凭据（已经退出登录）
+func (bot *Bot) removeSession() {
+  if bot.store == nil {
+    return
+  }
+  if e := bot.store.Remove(); e != nil {
+    bot.logger.Printf("wxweb: remove session failed: %v", e)
+  }
+}
+
+func (bot *Bot) restoreSession(sd *SessionData) bool {
+  if sd == nil || sd.Uin == 0 || sd.Sid == "" || sd.SKey == "" || sd.BaseUrl == "" {
+    return false
+  }
+  sk := parseSyncKeyString(sd.SyncKey)
+  if sk.Count == 0 {
+    return false
+  }
+  for k, v := range sd.Cookies {
+    addr, e := url.Parse(k)
+    if e != nil {
+      continue
+    }
+    bot.client.Jar.SetCookies(addr, v)
+  }
+  bot.mu.Lock()
+  defer bot.mu.Unlock()
+  bot.session.selectCluster(sd.Host)
+  // 保存的是上次能用的synccheck Host（可能是备用的）
+  if sd.SyncCheckHost != "" {
+    bot.session.SyncCheckHost = sd.SyncCheckHost
+  }
+  bot.session.SKey = sd.SKey
+  bot.session.Sid = sd.Sid
+  bot.session.Uin = sd.Uin
+  bot.session.PassTicket = sd.PassTicket
+  id := sd.DeviceId
+  if id == "" {
+    id = deviceId()
+  }
+  bot.session.BaseReq = baseReq{
+    DeviceId: id,
+    Sid:      sd.Sid,
+    SKey:     sd.SKey,
+    Uin:      sd.Uin,
+  }
+  bot.session.SyncKey = sk
+  bot.session.SyncCheckKey = sk
+  if sck := parseSyncKeyString(sd.SyncCheckKey); sck.Count > 0 {
+    bot.session.SyncCheckKey = sck
+  }
+  bot.session.UserName = sd.UserName
+  return true
+}
+
+// 解析expand后的SyncKey
+func parseSyncKeyString(s string) syncKey {
+  if s == "" {
+    return syncKey{}
+  }
+  arr := strings.Split(s, "|")
+  list := make([]syncKeyItem, 0, len(arr))
+  for _, v := range arr {
+    i := strings.Index(v, "_")
+    if i <= 0 {
+      return syncKey{}
+    }
+    key, e1 := strconv.Atoi(v[:i])
+    val, e2 := strconv.Atoi(v[i+1:])
+    if e1 != nil || e2 != nil {
+      return syncKey{}
+    }
+    list = append(list, syncKeyItem{key, val})
+  }
+  return syncKey{Count: len(list), List: list}
+}
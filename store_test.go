@@ -0,0 +1,141 @@
+package wxweb
+
+import (
+  "net/http"
+  "net/http/cookiejar"
+  "net/url"
+  "path"
+  "testing"
+  "time"
+)
+
+func TestCookieJarRecord(t *testing.T) {
+  u, _ := url.Parse("https://wx.qq.com/cgi-bin/mmwebwx-bin/webwxinit")
+  expires := time.Now().Add(time.Hour).Truncate(time.Second)
+  tests := []struct {
+    name       string
+    cookie     *http.Cookie
+    wantAddr   string
+    wantDomain string
+    wantExpiry bool
+  }{
+    {"host only", &http.Cookie{Name: "a", Value: "1"}, "https://wx.qq.com", "wx.qq.com", false},
+    {"domain", &http.Cookie{Name: "b", Value: "2", Domain: ".qq.com", Path: "/"}, "https://qq.com", "qq.com", false},
+    {"expires", &http.Cookie{Name: "c", Value: "3", Expires: expires}, "https://wx.qq.com", "wx.qq.com", true},
+    {"max age", &http.Cookie{Name: "d", Value: "4", MaxAge: 3600}, "https://wx.qq.com", "wx.qq.com", true},
+    {"expired", &http.Cookie{Name: "e", Value: "5", Expires: time.Now().Add(-time.Hour)}, "", "", false},
+    {"deleted", &http.Cookie{Name: "f", Value: "6", MaxAge: -1}, "", "", false},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      inner, _ := cookiejar.New(nil)
+      jar := newCookieJar(inner)
+      jar.SetCookies(u, []*http.Cookie{tt.cookie})
+      all := jar.all()
+      if tt.wantAddr == "" {
+        if len(all) != 0 {
+          t.Errorf("all() = %v, want empty", all)
+        }
+        return
+      }
+      arr := all[tt.wantAddr]
+      if len(arr) != 1 {
+        t.Fatalf("all() = %v, want one cookie at %s", all, tt.wantAddr)
+      }
+      c := arr[0]
+      if c.Name != tt.cookie.Name || c.Value != tt.cookie.Value || c.Domain != tt.wantDomain {
+        t.Errorf("cookie = %+v", c)
+      }
+      if c.Expires.IsZero() == tt.wantExpiry {
+        t.Errorf("Expires = %v, want set: %v", c.Expires, tt.wantExpiry)
+      }
+      if c.MaxAge != 0 {
+        t.Errorf("MaxAge = %d, want 0", c.MaxAge)
+      }
+    })
+  }
+}
+
+func TestSessionSaveRestore(t *testing.T) {
+  store := NewFileSessionStore(path.Join(tempDir(t), "session.json"))
+  bot := newTestBot(t, failingTransport(), WithSessionStore(store))
+  bot.session.SKey = "skey"
+  bot.session.Sid = "sid"
+  bot.session.PassTicket = "ticket"
+  bot.session.SyncKey = parseSyncKeyString("1_1|2_2")
+  bot.session.SyncCheckKey = parseSyncKeyString("1_1|2_3")
+  u, _ := url.Parse(bot.session.BaseUrl)
+  expires := time.Now().Add(time.Hour).Truncate(time.Second)
+  bot.client.Jar.SetCookies(u, []*http.Cookie{
+    {Name: "wxsid", Value: "sid", Domain: "qq.com", Path: "/", Expires: expires},
+  })
+  if e := bot.saveSession(); e != nil {
+    t.Fatal(e)
+  }
+
+  sd, e := store.Load()
+  if e != nil || sd == nil {
+    t.Fatalf("Load() = %v, %v", sd, e)
+  }
+  arr := sd.Cookies["https://qq.com"]
+  if len(arr) != 1 || arr[0].Domain != "qq.com" || !arr[0].Expires.Equal(expires) {
+    t.Fatalf("saved cookies = %v", sd.Cookies)
+  }
+
+  bot2 := newTestBot(t, failingTransport(), WithSessionStore(store))
+  if !bot2.restoreSession(sd) {
+    t.Fatal("restoreSession() = false")
+  }
+  if bot2.session.SKey != "skey" || bot2.session.SyncCheckKey.expand() != "1_1|2_3" {
+    t.Errorf("restored session = %+v", bot2.session)
+  }
+  // 恢复的是Domain Cookie，子域名（如webpush）也能用
+  push, _ := url.Parse("https://webpush.wx.qq.com/cgi-bin/mmwebwx-bin/synccheck")
+  if cs := bot2.client.Jar.Cookies(push); len(cs) != 1 || cs[0].Value != "sid" {
+    t.Errorf("cookies for %s = %v", push, cs)
+  }
+}
+
+// 在手机上退出（1101）后删除保存的凭据
+func TestLoopSignedOutRemovesSession(t *testing.T) {
+  store := NewFileSessionStore(path.Join(tempDir(t), "session.json"))
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "synccheck": `window.synccheck={retcode:"1101",selector:"0"}`,
+  }), WithSessionStore(store))
+  if e := store.Save(&SessionData{Uin: 1}); e != nil {
+    t.Fatal(e)
+  }
+  startTestLoop(t, bot, &testHandler{})
+  waitDone(t, bot)
+  if sd, e := store.Load(); sd != nil || e != nil {
+    t.Errorf("Load() = %v, %v, want nil, nil", sd, e)
+  }
+}
+
+// SyncKey变化后保存凭据
+func TestSyncSavesSessionOnKeyChange(t *testing.T) {
+  store := NewFileSessionStore(path.Join(tempDir(t), "session.json"))
+  bot := newTestBot(t, stubTransport(map[string]string{
+    "synccheck": `window.synccheck={retcode:"0",selector:"2"}`,
+    "webwxsync": `{"BaseResponse":{"Ret":0},"SyncKey":{"Count":1,"List":[{"Key":1,"Val":2}]},"ContinueFlag":0}`,
+  }), WithSessionStore(store))
+  bot.session.SyncKey = parseSyncKeyString("1_1")
+  startTestLoop(t, bot, &testHandler{})
+  saved := false
+  deadline := time.Now().Add(time.Second * 5)
+  for !saved && time.Now().Before(deadline) {
+    if sd, _ := store.Load(); sd != nil && sd.SyncKey == "1_2" {
+      saved = true
+    }
+    time.Sleep(time.Millisecond)
+  }
+  if !saved {
+    t.Error("session not saved after SyncKey changed")
+  }
+  // 主动退出登录也会删除凭据
+  bot.Stop()
+  waitDone(t, bot)
+  if sd, _ := store.Load(); sd != nil {
+    t.Errorf("saved session = %+v after Stop, want nil", sd)
+  }
+}
@@ -45,7 +45,7 @@ func (r *wxReq) cookie(key string) string {
   if key == "" {
     return ""
   }
-  addr, _ := url.Parse(r.session.BaseUrl)
+  addr, _ := url.Parse(r.sessionCopy().BaseUrl)
   arr := r.client.Jar.Cookies(addr)
   for _, c := range arr {
     if c.Name == key {
@@ -56,7 +56,11 @@ func (r *wxReq) cookie(key string) string {
 }
 
 func (r *wxReq) DownloadQRCode(dst string) (string, error) {
-  resp, e := http.Get(r.session.QRCodeUrl)
+  sess := r.sessionCopy()
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", sess.QRCodeUrl, nil)
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return "", e
   }
@@ -68,7 +72,7 @@ func (r *wxReq) DownloadQRCode(dst string) (string, error) {
   if e != nil {
     return "", e
   }
-  dump("DownloadQRCode_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("DownloadQRCode_"+r.now().Format(time2.DateTimeFormatMs5), body)
   if dst == "" {
     dst = path.Join(os.TempDir(), "wxweb_qrcode.jpg")
   }
@@ -80,7 +84,11 @@ func (r *wxReq) DownloadQRCode(dst string) (string, error) {
 }
 
 func (r *wxReq) DownloadAvatar(dst string) (string, error) {
-  resp, e := r.client.Get(r.session.AvatarUrl)
+  sess := r.sessionCopy()
+  req, _ := http.NewRequestWithContext(r.ctx, "GET", sess.AvatarUrl, nil)
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
+  resp, e := r.httpDo(req)
   if e != nil {
     return "", e
   }
@@ -92,9 +100,9 @@ func (r *wxReq) DownloadAvatar(dst string) (string, error) {
   if e != nil {
     return "", e
   }
-  dump("DownloadAvatar_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("DownloadAvatar_"+r.now().Format(time2.DateTimeFormatMs5), body)
   if dst == "" {
-    dst = path.Join(os.TempDir(), fmt.Sprintf("wxweb_%d.jpg", r.session.Uin))
+    dst = path.Join(os.TempDir(), fmt.Sprintf("wxweb_%d.jpg", sess.Uin))
   }
   e = ioutil.WriteFile(dst, body, os.ModePerm)
   if e != nil {
@@ -104,18 +112,29 @@ func (r *wxReq) DownloadAvatar(dst string) (string, error) {
 }
 
 func (r *wxReq) Verify(toUserName, ticket string) ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + verifyUrlPath)
+  return r.verifyUser(3, toUserName, ticket, "")
+}
+
+// 发送好友请求，content是验证信息
+func (r *wxReq) AddFriend(toUserName, ticket, content string) ([]byte, error) {
+  return r.verifyUser(2, toUserName, ticket, content)
+}
+
+// opcode=2：发送好友请求，opcode=3：通过好友验证
+func (r *wxReq) verifyUser(opcode int, toUserName, ticket, content string) ([]byte, error) {
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + verifyUrlPath)
   q := addr.Query()
   q.Set("r", timestampString13())
-  q.Set("pass_ticket", r.session.PassTicket)
+  q.Set("pass_ticket", sess.PassTicket)
   addr.RawQuery = q.Encode()
   m := make(map[string]interface{}, 8)
-  m["BaseRequest"] = r.session.BaseReq
-  m["skey"] = r.session.SKey
-  m["Opcode"] = 3
+  m["BaseRequest"] = sess.BaseReq
+  m["skey"] = sess.SKey
+  m["Opcode"] = opcode
   m["SceneListCount"] = 1
   m["SceneList"] = []int{33}
-  m["VerifyContent"] = ""
+  m["VerifyContent"] = content
   m["VerifyUserListSize"] = 1
   m["VerifyUserList"] = []map[string]string{
     {
@@ -124,11 +143,11 @@ func (r *wxReq) Verify(toUserName, ticket string) ([]byte, error) {
     },
   }
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", contentType)
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -140,26 +159,27 @@ func (r *wxReq) Verify(toUserName, ticket string) ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("Verify_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("Verify_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 func (r *wxReq) Remark(toUserName, remark string) ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + remarkUrlPath)
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + remarkUrlPath)
   q := addr.Query()
-  q.Set("pass_ticket", r.session.PassTicket)
+  q.Set("pass_ticket", sess.PassTicket)
   addr.RawQuery = q.Encode()
   m := make(map[string]interface{}, 4)
-  m["BaseRequest"] = r.session.BaseReq
+  m["BaseRequest"] = sess.BaseReq
   m["UserName"] = toUserName
   m["CmdId"] = 2
   m["RemarkName"] = remark
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", contentType)
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -171,12 +191,13 @@ func (r *wxReq) Remark(toUserName, remark string) ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("Remark_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("Remark_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 func (r *wxReq) GetContacts(toUserNames ...string) ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + batchContactsUrlPath)
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + batchContactsUrlPath)
   q := addr.Query()
   q.Set("type", "ex")
   q.Set("r", timestampString13())
@@ -189,15 +210,15 @@ func (r *wxReq) GetContacts(toUserNames ...string) ([]byte, error) {
     arr = append(arr, m)
   }
   m := make(map[string]interface{}, 3)
-  m["BaseRequest"] = r.session.BaseReq
+  m["BaseRequest"] = sess.BaseReq
   m["Count"] = len(toUserNames)
   m["List"] = arr
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", contentType)
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -209,25 +230,26 @@ func (r *wxReq) GetContacts(toUserNames ...string) ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("GetContacts_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("GetContacts_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 func (r *wxReq) SignOut() ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + signOutUrlPath)
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + signOutUrlPath)
   q := addr.Query()
   q.Set("redirect", "1")
   q.Set("type", "1")
-  q.Set("skey", r.session.SKey)
+  q.Set("skey", sess.SKey)
   addr.RawQuery = q.Encode()
   form := url.Values{}
-  form.Set("sid", r.session.Sid)
-  form.Set("uin", strconv.FormatInt(r.session.Uin, 10))
-  req, _ := http.NewRequest("POST", addr.String(), strings.NewReader(form.Encode()))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  form.Set("sid", sess.Sid)
+  form.Set("uin", strconv.FormatInt(sess.Uin, 10))
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), strings.NewReader(form.Encode()))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -239,35 +261,36 @@ func (r *wxReq) SignOut() ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("SignOut_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("SignOut_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 func (r *wxReq) SendText(toUserName, text string) ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + sendTextUrlPath)
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + sendTextUrlPath)
   q := addr.Query()
-  q.Set("pass_ticket", r.session.PassTicket)
+  q.Set("pass_ticket", sess.PassTicket)
   addr.RawQuery = q.Encode()
   n, _ := strconv.ParseInt(timestampString13(), 10, 32)
   s := strconv.FormatInt(n<<4, 10) + timestampStringR(4)
   params := map[string]interface{}{
     "Type":         MsgText,
     "Content":      text,
-    "FromUserName": r.session.UserName,
+    "FromUserName": sess.UserName,
     "ToUserName":   toUserName,
     "LocalID":      s,
     "ClientMsgId":  s,
   }
   m := make(map[string]interface{}, 3)
-  m["BaseRequest"] = r.session.BaseReq
+  m["BaseRequest"] = sess.BaseReq
   m["Scene"] = 0
   m["Msg"] = params
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", contentType)
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -279,38 +302,39 @@ func (r *wxReq) SendText(toUserName, text string) ([]byte, error) {
   if e != nil {
     return nil, e
   }
-  dump("SendText_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("SendText_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 func (r *wxReq) SendMedia(toUserName, mediaId string, msgType int, sendUrlPath string) ([]byte, error) {
-  addr, _ := url.Parse(r.session.BaseUrl + sendUrlPath)
+  sess := r.sessionCopy()
+  addr, _ := url.Parse(sess.BaseUrl + sendUrlPath)
   q := addr.Query()
   q.Set("fun", "async")
   q.Set("f", "json")
-  q.Set("pass_ticket", r.session.PassTicket)
+  q.Set("pass_ticket", sess.PassTicket)
   addr.RawQuery = q.Encode()
   n, _ := strconv.ParseInt(timestampString13(), 10, 32)
   s := strconv.FormatInt(n<<4, 10) + timestampStringR(4)
   params := map[string]interface{}{
     "Type":         msgType,
     "MediaId":      mediaId,
-    "FromUserName": r.session.UserName,
+    "FromUserName": sess.UserName,
     "ToUserName":   toUserName,
     "LocalID":      s,
     "ClientMsgId":  s,
     "Content":      "",
   }
   m := make(map[string]interface{}, 3)
-  m["BaseRequest"] = r.session.BaseReq
+  m["BaseRequest"] = sess.BaseReq
   m["Scene"] = 0
   m["Msg"] = params
   buf, _ := json.Marshal(m)
-  req, _ := http.NewRequest("POST", addr.String(), bytes.NewReader(buf))
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", addr.String(), bytes.NewReader(buf))
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", contentType)
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return nil, e
   }
@@ -322,16 +346,17 @@ func (r *wxReq) SendMedia(toUserName, mediaId string, msgType int, sendUrlPath s
   if e != nil {
     return nil, e
   }
-  dump("SendMedia_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("SendMedia_"+r.now().Format(time2.DateTimeFormatMs5), body)
   return body, nil
 }
 
 // data是上传的数据，如果大于chunk则按chunk分块上传，
 // filename是文件名（非文件路径，用来检测文件类型和设置上传文件名，如1.png）
 func (r *wxReq) UploadMedia(toUserName string, data []byte, filename string) (string, error) {
+  sess := r.sessionCopy()
   l := len(data)
-  addr, _ := url.Parse(r.session.BaseUrl + uploadUrlPath)
-  addr.Host = "file." + addr.Host
+  addr, _ := url.Parse(sess.BaseUrl + uploadUrlPath)
+  addr.Host = sess.FileHost
   q := addr.Query()
   q.Set("f", "json")
   addr.RawQuery = q.Encode()
@@ -357,14 +382,14 @@ func (r *wxReq) UploadMedia(toUserName string, data []byte, filename string) (st
   n, _ := strconv.ParseInt(timestampString13(), 10, 32)
   s := strconv.FormatInt(n<<4, 10) + timestampStringR(4)
   m := make(map[string]interface{}, 10)
-  m["BaseRequest"] = r.session.BaseReq
+  m["BaseRequest"] = sess.BaseReq
   m["UploadType"] = 2
   m["ClientMediaId"] = s
   m["TotalLen"] = l
   m["DataLen"] = l
   m["StartPos"] = 0
   m["MediaType"] = 4
-  m["FromUserName"] = r.session.UserName
+  m["FromUserName"] = sess.UserName
   m["ToUserName"] = toUserName
   m["FileMd5"] = hash
   payload, _ := json.Marshal(m)
@@ -377,15 +402,14 @@ func (r *wxReq) UploadMedia(toUserName string, data []byte, filename string) (st
     mimeType:     mimeType,
     mediaType:    mediaType,
     payload:      string(payload),
-    fromUserName: r.session.UserName,
+    fromUserName: sess.UserName,
     toUserName:   toUserName,
     dataTicket:   r.cookie("webwx_data_ticket"),
     totalLen:     l,
-    wuFile:       r.session.WuFile,
+    wuFile:       r.nextWuFile(),
     chunks:       0,
     chunk:        0,
   }
-  defer func() { r.session.WuFile++ }()
 
   var mediaId string
   var err error
@@ -401,6 +425,9 @@ func (r *wxReq) UploadMedia(toUserName string, data []byte, filename string) (st
       info.chunks = m + 1
     }
     for i := 0; i < m; i++ {
+      if err = r.ctx.Err(); err != nil {
+        break
+      }
       s := i * chunkSize
       e := s + chunkSize
       info.chunk = i
@@ -420,13 +447,14 @@ func (r *wxReq) UploadMedia(toUserName string, data []byte, filename string) (st
 }
 
 func (r *wxReq) uploadChunk(info *uploadInfo) (string, error) {
+  sess := r.sessionCopy()
   var buf bytes.Buffer
   w := multipart.NewWriter(&buf)
   defer w.Close()
   w.WriteField("id", fmt.Sprintf("WU_FILE_%d", info.wuFile))
   w.WriteField("name", info.filename)
   w.WriteField("type", info.mimeType)
-  w.WriteField("lastModifiedDate", time2.Shanghai().Add(time.Hour * -24).Format(dateTimeFormat))
+  w.WriteField("lastModifiedDate", r.now().Add(time.Hour * -24).Format(dateTimeFormat))
   w.WriteField("size", strconv.Itoa(info.totalLen))
   if info.chunks > 0 {
     w.WriteField("chunks", strconv.Itoa(info.chunks))
@@ -435,7 +463,7 @@ func (r *wxReq) uploadChunk(info *uploadInfo) (string, error) {
   w.WriteField("mediatype", info.mediaType)
   w.WriteField("uploadmediarequest", info.payload)
   w.WriteField("webwx_data_ticket", info.dataTicket)
-  w.WriteField("pass_ticket", r.session.PassTicket)
+  w.WriteField("pass_ticket", sess.PassTicket)
   fw, e := w.CreateFormFile("filename", info.filename)
   if e != nil {
     return "", e
@@ -444,11 +472,11 @@ func (r *wxReq) uploadChunk(info *uploadInfo) (string, error) {
     return "", e
   }
 
-  req, _ := http.NewRequest("POST", info.addr, &buf)
-  req.Header.Set("Referer", r.session.Referer)
-  req.Header.Set("User-Agent", userAgent)
+  req, _ := http.NewRequestWithContext(r.ctx, "POST", info.addr, &buf)
+  req.Header.Set("Referer", sess.Referer)
+  req.Header.Set("User-Agent", r.userAgent)
   req.Header.Set("Content-Type", w.FormDataContentType())
-  resp, e := r.client.Do(req)
+  resp, e := r.httpDo(req)
   if e != nil {
     return "", e
   }
@@ -460,7 +488,7 @@ func (r *wxReq) uploadChunk(info *uploadInfo) (string, error) {
   if e != nil {
     return "", e
   }
-  dump("uploadChunk_"+time2.ShanghaiStrf(time2.DateTimeFormatMs5), body)
+  r.dump("uploadChunk_"+r.now().Format(time2.DateTimeFormatMs5), body)
   mediaId, _ := jsonparser.GetString(body, "MediaId")
   return mediaId, nil
 }